@@ -0,0 +1,480 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuditSeverity int
+
+const (
+	AUDIT_INFO AuditSeverity = iota
+	AUDIT_LOW
+	AUDIT_MEDIUM
+	AUDIT_HIGH
+	AUDIT_CRITICAL
+)
+
+// points subtracted from the score of 100 for each finding
+var auditPenalties = map[AuditSeverity]int{
+	AUDIT_INFO:     0,
+	AUDIT_LOW:      5,
+	AUDIT_MEDIUM:   10,
+	AUDIT_HIGH:     20,
+	AUDIT_CRITICAL: 40,
+}
+
+// AuditSeverity as string
+func (s AuditSeverity) String() string {
+	switch s {
+	case AUDIT_INFO:
+		return "INFO"
+	case AUDIT_LOW:
+		return "LOW"
+	case AUDIT_MEDIUM:
+		return "MEDIUM"
+	case AUDIT_HIGH:
+		return "HIGH"
+	case AUDIT_CRITICAL:
+		return "CRITICAL"
+	}
+	return "<INVALID>"
+}
+
+// Encodes the severity as its name
+func (s AuditSeverity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// A single issue found by Audit
+type AuditFinding struct {
+	Check    string        `json:"check"`
+	Severity AuditSeverity `json:"severity"`
+	Message  string        `json:"message"`
+	Key      string        `json:"key,omitempty"`
+	Value    string        `json:"value,omitempty"`
+}
+
+// The result of auditing a container. Score starts at 100 and every finding
+// lowers it according to its severity.
+type AuditReport struct {
+	Container       string         `json:"container"`
+	Time            time.Time      `json:"time"`
+	Running         bool           `json:"running"`
+	Privileged      bool           `json:"privileged"`
+	Capabilities    []string       `json:"capabilities"`
+	AppArmorProfile string         `json:"apparmor_profile,omitempty"`
+	SeccompProfile  string         `json:"seccomp_profile,omitempty"`
+	Nesting         bool           `json:"nesting"`
+	Score           int            `json:"score"`
+	Findings        []AuditFinding `json:"findings"`
+}
+
+// Returns the report as indented JSON
+func (r *AuditReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// Returns the report as human readable text
+func (r *AuditReport) String() string {
+	var buf bytes.Buffer
+
+	yesno := map[bool]string{true: "yes", false: "no"}
+	fmt.Fprintf(&buf, "Container:    %s\n", r.Container)
+	fmt.Fprintf(&buf, "Score:        %d/100\n", r.Score)
+	fmt.Fprintf(&buf, "Running:      %s\n", yesno[r.Running])
+	fmt.Fprintf(&buf, "Privileged:   %s\n", yesno[r.Privileged])
+	fmt.Fprintf(&buf, "Nesting:      %s\n", yesno[r.Nesting])
+	fmt.Fprintf(&buf, "AppArmor:     %s\n", orNone(r.AppArmorProfile))
+	fmt.Fprintf(&buf, "Seccomp:      %s\n", orNone(r.SeccompProfile))
+	fmt.Fprintf(&buf, "Capabilities: %s\n", orNone(strings.Join(r.Capabilities, " ")))
+
+	if len(r.Findings) == 0 {
+		fmt.Fprintf(&buf, "Findings:     none\n")
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, "Findings:\n")
+	for _, f := range r.Findings {
+		fmt.Fprintf(&buf, "  [%s] %s: %s\n", f.Severity, f.Check, f.Message)
+	}
+	return buf.String()
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return s
+}
+
+func (r *AuditReport) add(check string, severity AuditSeverity, key string, value string, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, AuditFinding{
+		Check:    check,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+		Key:      key,
+		Value:    value,
+	})
+}
+
+// Capability names indexed by their number
+var capabilityNames = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+// Capabilities that allow escaping or attacking the host, with the severity of
+// retaining them in a privileged container
+var dangerousCapabilities = map[string]AuditSeverity{
+	"sys_admin":       AUDIT_HIGH,
+	"sys_module":      AUDIT_CRITICAL,
+	"sys_rawio":       AUDIT_HIGH,
+	"sys_time":        AUDIT_MEDIUM,
+	"sys_boot":        AUDIT_MEDIUM,
+	"sys_ptrace":      AUDIT_MEDIUM,
+	"mac_admin":       AUDIT_HIGH,
+	"mac_override":    AUDIT_HIGH,
+	"dac_read_search": AUDIT_HIGH,
+	"net_admin":       AUDIT_LOW,
+	"syslog":          AUDIT_LOW,
+	"bpf":             AUDIT_MEDIUM,
+	"perfmon":         AUDIT_LOW,
+}
+
+// Host paths that should never be bind mounted into a container, with the
+// severity of a writable mount
+var sensitiveMountSources = map[string]AuditSeverity{
+	"/":                    AUDIT_CRITICAL,
+	"/boot":                AUDIT_HIGH,
+	"/dev":                 AUDIT_HIGH,
+	"/etc":                 AUDIT_HIGH,
+	"/proc":                AUDIT_HIGH,
+	"/root":                AUDIT_HIGH,
+	"/sys":                 AUDIT_HIGH,
+	"/var/lib/lxc":         AUDIT_HIGH,
+	"/var/run/docker.sock": AUDIT_CRITICAL,
+	"/run/docker.sock":     AUDIT_CRITICAL,
+	"/home":                AUDIT_MEDIUM,
+	"/lib/modules":         AUDIT_MEDIUM,
+	"/run":                 AUDIT_MEDIUM,
+	"/usr":                 AUDIT_MEDIUM,
+	"/var/run":             AUDIT_MEDIUM,
+}
+
+// Inspects the configuration and, if the container is running, the runtime
+// state of its init process for settings weakening the isolation from the host.
+func Audit(c *Container) (*AuditReport, error) {
+	if !c.Defined() {
+		return nil, ErrNotDefined
+	}
+
+	report := &AuditReport{
+		Container: c.Name(),
+		Time:      time.Now(),
+		Running:   c.Running(),
+	}
+
+	pid := -1
+	if report.Running {
+		pid = c.InitPID()
+	}
+
+	auditPrivileged(c, report, pid)
+	auditCapabilities(c, report, pid)
+	auditAppArmor(c, report, pid)
+	auditSeccomp(c, report, pid)
+	auditDevices(c, report)
+	auditMounts(c, report)
+	auditNesting(c, report)
+
+	report.Score = 100
+	for _, f := range report.Findings {
+		report.Score -= auditPenalties[f.Severity]
+	}
+	if report.Score < 0 {
+		report.Score = 0
+	}
+	return report, nil
+}
+
+// configuration values of a key, unset and unknown keys are treated alike
+func auditConfigList(c *Container, key string) []string {
+	values, _, _ := c.ConfigList(key)
+	return values
+}
+
+func auditConfigValue(c *Container, key string) string {
+	value, _, _ := c.ConfigValue(key)
+	return value
+}
+
+func auditPrivileged(c *Container, r *AuditReport, pid int) {
+	r.Privileged = len(auditConfigList(c, "lxc.idmap")) == 0
+
+	if pid > 0 {
+		// the init process of a privileged container shares the host's uid range
+		if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/uid_map", pid)); err == nil {
+			fields := strings.Fields(string(data))
+			r.Privileged = len(fields) >= 3 && fields[0] == "0" && fields[1] == "0"
+		}
+	}
+
+	if r.Privileged {
+		r.add("privileged", AUDIT_HIGH, "lxc.idmap", "", "container runs without a user namespace, root in the container is root on the host")
+	}
+}
+
+func auditCapabilities(c *Container, r *AuditReport, pid int) {
+	var caps []string
+
+	if effective, ok := processCapabilities(pid); ok {
+		caps = effective
+	} else if keep := splitConfigWords(auditConfigList(c, "lxc.cap.keep")); len(keep) > 0 {
+		if !(len(keep) == 1 && keep[0] == "none") {
+			caps = keep
+		}
+	} else {
+		dropped := make(map[string]bool)
+		for _, name := range splitConfigWords(auditConfigList(c, "lxc.cap.drop")) {
+			dropped[name] = true
+		}
+		for _, name := range capabilityNames {
+			if !dropped[name] {
+				caps = append(caps, name)
+			}
+		}
+	}
+	r.Capabilities = caps
+
+	for _, name := range caps {
+		severity, ok := dangerousCapabilities[name]
+		if !ok {
+			continue
+		}
+		// capabilities of unprivileged containers only apply to resources
+		// owned by their user namespace
+		if !r.Privileged {
+			severity = AUDIT_INFO
+		}
+		r.add("capabilities", severity, "lxc.cap.drop", name, "capability %s is retained", name)
+	}
+}
+
+// Returns the effective capabilities of the given process
+func processCapabilities(pid int) ([]string, bool) {
+	if pid <= 0 {
+		return nil, false
+	}
+	value, ok := procStatusField(pid, "CapEff")
+	if !ok {
+		return nil, false
+	}
+	mask, err := strconv.ParseUint(value, 16, 64)
+	if err != nil {
+		return nil, false
+	}
+	return decodeCapabilities(mask), true
+}
+
+func decodeCapabilities(mask uint64) []string {
+	caps := []string{}
+	for i, name := range capabilityNames {
+		if mask&(1<<uint(i)) != 0 {
+			caps = append(caps, name)
+		}
+	}
+	return caps
+}
+
+// Returns the value of a field in /proc/<pid>/status
+func procStatusField(pid int, field string) (string, bool) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), ":"); ok && key == field {
+			return strings.TrimSpace(value), true
+		}
+	}
+	return "", false
+}
+
+func splitConfigWords(values []string) []string {
+	var words []string
+	for _, v := range values {
+		words = append(words, strings.Fields(strings.ToLower(v))...)
+	}
+	return words
+}
+
+func auditAppArmor(c *Container, r *AuditReport, pid int) {
+	r.AppArmorProfile = auditConfigValue(c, "lxc.apparmor.profile")
+	if pid > 0 {
+		if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/attr/current", pid)); err == nil {
+			r.AppArmorProfile = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(string(data)), "(enforce)"))
+		}
+	}
+
+	if r.AppArmorProfile == "unconfined" {
+		r.add("apparmor", AUDIT_HIGH, "lxc.apparmor.profile", r.AppArmorProfile, "container is not confined by AppArmor")
+	}
+	if auditConfigValue(c, "lxc.apparmor.allow_incomplete") == "1" {
+		r.add("apparmor", AUDIT_MEDIUM, "lxc.apparmor.allow_incomplete", "1", "container starts even if the AppArmor profile can't be fully applied")
+	}
+}
+
+func auditSeccomp(c *Container, r *AuditReport, pid int) {
+	r.SeccompProfile = auditConfigValue(c, "lxc.seccomp.profile")
+
+	filtered := r.SeccompProfile != ""
+	if pid > 0 {
+		// 2 is SECCOMP_MODE_FILTER
+		if mode, ok := procStatusField(pid, "Seccomp"); ok {
+			filtered = mode == "2"
+		}
+	}
+	if !filtered {
+		r.add("seccomp", AUDIT_MEDIUM, "lxc.seccomp.profile", "", "no seccomp filter restricts the system calls of the container")
+	}
+}
+
+var deviceTypes = map[string]string{
+	"b": "block",
+	"c": "character",
+}
+
+func auditDevices(c *Container, r *AuditReport) {
+	denyAll := false
+	for _, prefix := range []string{"lxc.cgroup.", "lxc.cgroup2."} {
+		for _, rule := range auditConfigList(c, prefix+"devices.deny") {
+			if rule == "a" || strings.HasPrefix(rule, "a ") {
+				denyAll = true
+			}
+		}
+
+		for _, rule := range auditConfigList(c, prefix+"devices.allow") {
+			fields := strings.Fields(rule)
+			if len(fields) == 0 {
+				continue
+			}
+			switch {
+			case fields[0] == "a":
+				r.add("devices", AUDIT_CRITICAL, prefix+"devices.allow", rule, "all devices are accessible")
+			case len(fields) >= 3 && fields[1] == "*:*" && strings.ContainsAny(fields[2], "rw"):
+				// "c *:* m" only allows mknod and is part of the default configuration
+				r.add("devices", AUDIT_HIGH, prefix+"devices.allow", rule, "read or write access to all %s devices", deviceTypes[fields[0]])
+			}
+		}
+	}
+
+	if !denyAll && r.Privileged {
+		r.add("devices", AUDIT_MEDIUM, "lxc.cgroup.devices.deny", "", "devices cgroup doesn't deny access by default")
+	}
+}
+
+func auditMounts(c *Container, r *AuditReport) {
+	for _, entry := range auditConfigList(c, "lxc.mount.entry") {
+		auditMountEntry(r, "lxc.mount.entry", entry)
+	}
+	// entries of the fstab file are mounted like lxc.mount.entry ones
+	if fstab := auditConfigValue(c, "lxc.mount.fstab"); fstab != "" {
+		if data, err := os.ReadFile(fstab); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				line = strings.TrimSpace(line)
+				if line != "" && !strings.HasPrefix(line, "#") {
+					auditMountEntry(r, "lxc.mount.fstab", line)
+				}
+			}
+		}
+	}
+
+	for _, option := range splitConfigWords(auditConfigList(c, "lxc.mount.auto")) {
+		if option == "proc:rw" || option == "sys:rw" || strings.HasPrefix(option, "cgroup-full:rw") {
+			severity := AUDIT_MEDIUM
+			if r.Privileged {
+				severity = AUDIT_HIGH
+			}
+			r.add("mounts", severity, "lxc.mount.auto", option, "%s gives write access to host kernel interfaces", option)
+		}
+	}
+}
+
+func auditMountEntry(r *AuditReport, key string, entry string) {
+	fields := strings.Fields(entry)
+	if len(fields) < 4 {
+		return
+	}
+	options := strings.Split(fields[3], ",")
+	if !containsString(options, "bind") && !containsString(options, "rbind") {
+		return
+	}
+
+	source := filepath.Clean(fields[0])
+	severity, ok := sensitiveMountSource(source)
+	if !ok {
+		return
+	}
+	if containsString(options, "ro") && severity > AUDIT_LOW {
+		severity--
+	}
+	r.add("mounts", severity, key, entry, "host path %s is bind mounted", source)
+}
+
+// Returns the severity of the closest sensitive path source is, or is below.
+// The host root only counts when it is mounted itself.
+func sensitiveMountSource(source string) (AuditSeverity, bool) {
+	source = filepath.Clean("/" + source)
+	for dir := source; ; dir = filepath.Dir(dir) {
+		if severity, ok := sensitiveMountSources[dir]; ok && (dir != "/" || source == "/") {
+			return severity, true
+		}
+		if dir == "/" {
+			return 0, false
+		}
+	}
+}
+
+func auditNesting(c *Container, r *AuditReport) {
+	if auditConfigValue(c, "lxc.apparmor.allow_nesting") == "1" || strings.Contains(r.AppArmorProfile, "nesting") {
+		r.Nesting = true
+	}
+	for _, option := range splitConfigWords(auditConfigList(c, "lxc.mount.auto")) {
+		if option == "cgroup:rw" || option == "cgroup:rw:force" {
+			r.Nesting = true
+		}
+	}
+
+	if r.Nesting {
+		severity := AUDIT_LOW
+		if r.Privileged {
+			severity = AUDIT_MEDIUM
+		}
+		r.add("nesting", severity, "lxc.apparmor.allow_nesting", "", "container is allowed to run nested containers")
+	}
+}
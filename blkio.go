@@ -0,0 +1,271 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	minBlockIOWeight = 10
+	maxBlockIOWeight = 1000
+	minIOWeight      = 1
+	maxIOWeight      = 10000
+)
+
+// I/O done by a container on a single block device
+type BlockIOStat struct {
+	Major      uint32
+	Minor      uint32
+	Device     string
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadOps    uint64
+	WriteOps   uint64
+}
+
+// Returns the bytes and operations read and written by the container per block
+// device
+func (lxc *Container) BlockIOStats() ([]BlockIOStat, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+	return lxc.blockIOStats()
+}
+
+func (lxc *Container) blockIOStats() ([]BlockIOStat, error) {
+	var stats []BlockIOStat
+	var err error
+
+	if unifiedCgroup() {
+		lines := lxc.cgroupItem("io.stat")
+		stats, err = parseIOStat(lines)
+	} else {
+		// the recursive files include the I/O of nested cgroups but are
+		// missing on older kernels
+		bytes := lxc.cgroupItem("blkio.throttle.io_service_bytes_recursive")
+		ops := lxc.cgroupItem("blkio.throttle.io_serviced_recursive")
+		if bytes[0] == "" {
+			bytes = lxc.cgroupItem("blkio.throttle.io_service_bytes")
+			ops = lxc.cgroupItem("blkio.throttle.io_serviced")
+		}
+		stats, err = parseBlkioStats(bytes, ops)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range stats {
+		stats[i].Device = blockDeviceName(stats[i].Major, stats[i].Minor)
+	}
+	return stats, nil
+}
+
+// Parses io.stat lines like "8:0 rbytes=1 wbytes=2 rios=3 wios=4 dbytes=0 dios=0"
+func parseIOStat(lines []string) ([]BlockIOStat, error) {
+	var stats []BlockIOStat
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		stat := BlockIOStat{}
+		if err := parseDeviceNumber(fields[0], &stat.Major, &stat.Minor); err != nil {
+			return nil, err
+		}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			switch key {
+			case "rbytes":
+				stat.ReadBytes = n
+			case "wbytes":
+				stat.WriteBytes = n
+			case "rios":
+				stat.ReadOps = n
+			case "wios":
+				stat.WriteOps = n
+			}
+		}
+		stats = append(stats, stat)
+	}
+	return stats, nil
+}
+
+// Parses the blkio.throttle.io_service_bytes and blkio.throttle.io_serviced
+// lines like "8:0 Read 4096"
+func parseBlkioStats(bytes []string, ops []string) ([]BlockIOStat, error) {
+	devices := make(map[string]*BlockIOStat)
+
+	parse := func(lines []string, read func(*BlockIOStat) *uint64, write func(*BlockIOStat) *uint64) error {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			if len(fields) != 3 || (fields[1] != "Read" && fields[1] != "Write") {
+				continue
+			}
+			stat, ok := devices[fields[0]]
+			if !ok {
+				stat = &BlockIOStat{}
+				if err := parseDeviceNumber(fields[0], &stat.Major, &stat.Minor); err != nil {
+					return err
+				}
+				devices[fields[0]] = stat
+			}
+			n, err := strconv.ParseUint(fields[2], 10, 64)
+			if err != nil {
+				return err
+			}
+			if fields[1] == "Read" {
+				*read(stat) = n
+			} else {
+				*write(stat) = n
+			}
+		}
+		return nil
+	}
+
+	err := parse(bytes,
+		func(s *BlockIOStat) *uint64 { return &s.ReadBytes },
+		func(s *BlockIOStat) *uint64 { return &s.WriteBytes })
+	if err != nil {
+		return nil, err
+	}
+	err = parse(ops,
+		func(s *BlockIOStat) *uint64 { return &s.ReadOps },
+		func(s *BlockIOStat) *uint64 { return &s.WriteOps })
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]BlockIOStat, 0, len(devices))
+	for _, stat := range devices {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Major != stats[j].Major {
+			return stats[i].Major < stats[j].Major
+		}
+		return stats[i].Minor < stats[j].Minor
+	})
+	return stats, nil
+}
+
+func parseDeviceNumber(s string, major *uint32, minor *uint32) error {
+	maj, min, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidDevice, s)
+	}
+	n, err := strconv.ParseUint(maj, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidDevice, s)
+	}
+	m, err := strconv.ParseUint(min, 10, 32)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrInvalidDevice, s)
+	}
+	*major, *minor = uint32(n), uint32(m)
+	return nil
+}
+
+// Returns the kernel name (like sda) of the given block device
+func blockDeviceName(major uint32, minor uint32) string {
+	target, err := os.Readlink(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// Returns "major:minor" of a device given either as a path like /dev/sda or
+// already in major:minor format
+func blockDeviceNumber(device string) (string, error) {
+	var major, minor uint32
+	if parseDeviceNumber(device, &major, &minor) == nil {
+		return fmt.Sprintf("%d:%d", major, minor), nil
+	}
+
+	var st syscall.Stat_t
+	if err := syscall.Stat(device, &st); err != nil {
+		return "", err
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFBLK {
+		return "", fmt.Errorf("%w: %s is not a block device", ErrInvalidDevice, device)
+	}
+	rdev := uint64(st.Rdev)
+	major = uint32((rdev>>8)&0xfff | (rdev>>32)&^0xfff)
+	minor = uint32(rdev&0xff | (rdev>>12)&^0xff)
+	return fmt.Sprintf("%d:%d", major, minor), nil
+}
+
+// Throttles the I/O of the container on the given device, which is either a
+// path like /dev/sda or in major:minor format. Zero values remove the
+// corresponding limit.
+func (lxc *Container) SetBlockIOLimit(device string, rbps ByteSize, wbps ByteSize, riops uint64, wiops uint64) error {
+	if rbps < 0 || wbps < 0 {
+		return &InvalidLimitError{Limit: "block I/O", Value: fmt.Sprintf("%s/%s", rbps, wbps), Reason: "must not be negative"}
+	}
+	dev, err := blockDeviceNumber(device)
+	if err != nil {
+		return err
+	}
+
+	if unifiedCgroup() {
+		value := fmt.Sprintf("%s rbps=%s wbps=%s riops=%s wiops=%s", dev,
+			ioMax(uint64(rbps)), ioMax(uint64(wbps)), ioMax(riops), ioMax(wiops))
+		return lxc.setCgroupLimits(cgroupSetting{key: "io.max", value: value, device: dev})
+	}
+	return lxc.setCgroupLimits(
+		cgroupSetting{key: "blkio.throttle.read_bps_device", value: fmt.Sprintf("%s %d", dev, uint64(rbps)), device: dev},
+		cgroupSetting{key: "blkio.throttle.write_bps_device", value: fmt.Sprintf("%s %d", dev, uint64(wbps)), device: dev},
+		cgroupSetting{key: "blkio.throttle.read_iops_device", value: fmt.Sprintf("%s %d", dev, riops), device: dev},
+		cgroupSetting{key: "blkio.throttle.write_iops_device", value: fmt.Sprintf("%s %d", dev, wiops), device: dev},
+	)
+}
+
+func ioMax(limit uint64) string {
+	if limit == 0 {
+		return "max"
+	}
+	return strconv.FormatUint(limit, 10)
+}
+
+// Sets the relative I/O weight of the container (10-1000, 500 being the
+// default). On cgroup v2 hosts the value is converted to io.weight.
+func (lxc *Container) SetBlockIOWeight(weight uint64) error {
+	if weight < minBlockIOWeight || weight > maxBlockIOWeight {
+		return &InvalidLimitError{Limit: "block I/O weight", Value: strconv.FormatUint(weight, 10),
+			Reason: fmt.Sprintf("must be between %d and %d", minBlockIOWeight, maxBlockIOWeight)}
+	}
+
+	if unifiedCgroup() {
+		value := "default " + strconv.FormatUint(blkioWeightToIOWeight(weight), 10)
+		return lxc.setCgroupLimits(cgroupSetting{key: "io.weight", value: value})
+	}
+	return lxc.setCgroupLimits(cgroupSetting{key: "blkio.weight", value: strconv.FormatUint(weight, 10)})
+}
+
+func blkioWeightToIOWeight(weight uint64) uint64 {
+	return minIOWeight + ((weight-minBlockIOWeight)*(maxIOWeight-minIOWeight))/(maxBlockIOWeight-minBlockIOWeight)
+}
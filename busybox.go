@@ -0,0 +1,247 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"archive/tar"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// applets linked if the busybox binary can't list its own
+var busyboxApplets = []string{
+	"bin/sh", "bin/ash", "bin/cat", "bin/cp", "bin/echo", "bin/hostname",
+	"bin/kill", "bin/ln", "bin/ls", "bin/mkdir", "bin/mount", "bin/mv",
+	"bin/ps", "bin/rm", "bin/sleep", "bin/umount", "bin/vi", "sbin/halt",
+	"sbin/init", "sbin/poweroff", "sbin/reboot",
+}
+
+// directories searched for the shared libraries of the busybox binary, next
+// to the directory of the dynamic loader
+var libraryDirs = []string{"/lib64", "/usr/lib64", "/lib", "/usr/lib"}
+
+// Populates the rootfs with a minimal system built from the host's busybox
+// binary and, if it isn't static, the shared libraries it needs. busybox's
+// init runs a shell on the console.
+type BusyboxTemplate struct {
+	// busybox binary of the host, looked up in PATH if empty
+	Path string
+}
+
+// Populate implements Template
+func (t *BusyboxTemplate) Populate(tw *tar.Writer) error {
+	binary := t.Path
+	if binary == "" {
+		var err error
+		if binary, err = exec.LookPath("busybox"); err != nil {
+			return err
+		}
+	}
+	libraries, err := sharedLibraries(binary)
+	if err != nil {
+		return fmt.Errorf("%s: %w", binary, err)
+	}
+
+	w := &templateWriter{tw: tw, modTime: time.Now()}
+	for _, dir := range []string{"bin", "sbin", "usr/bin", "usr/sbin", "etc/init.d", "dev", "proc", "sys", "run", "var/log", "home", "mnt"} {
+		w.dir(dir, 0755)
+	}
+	w.dir("root", 0700)
+	w.dir("tmp", 01777)
+
+	w.copyFile("bin/busybox", binary, 0755)
+	for _, library := range libraries {
+		w.copyFile(strings.TrimPrefix(library, "/"), library, 0755)
+	}
+
+	applets := busyboxApplets
+	if out, err := exec.Command(binary, "--list-full").Output(); err == nil {
+		applets = strings.Fields(string(out))
+	}
+	init := false
+	for _, applet := range applets {
+		applet = strings.TrimPrefix(path.Clean("/"+applet), "/")
+		if applet == "bin/busybox" || path.Dir(applet) == "." {
+			continue
+		}
+		w.dir(path.Dir(applet), 0755)
+		w.symlink(applet, "/bin/busybox")
+		init = init || applet == "sbin/init"
+	}
+	if !init {
+		w.symlink("sbin/init", "/bin/busybox")
+	}
+
+	w.file("etc/passwd", "root:x:0:0:root:/root:/bin/sh\n", 0644)
+	w.file("etc/shadow", "root:*:0:0:99999:7:::\n", 0600)
+	w.file("etc/group", "root:x:0:\n", 0644)
+	w.file("etc/fstab", "tmpfs /tmp tmpfs defaults 0 0\n", 0644)
+	w.file("etc/inittab", "::sysinit:/etc/init.d/rcS\n"+
+		"console::askfirst:/bin/sh\n"+
+		"::ctrlaltdel:/sbin/reboot\n"+
+		"::shutdown:/bin/umount -a -r\n", 0644)
+	w.file("etc/init.d/rcS", "#!/bin/sh\n/bin/mount -a\n", 0755)
+	return w.err
+}
+
+// Configure implements Template
+func (t *BusyboxTemplate) Configure(tx *ConfigTx) error {
+	tx.Set("lxc.init.cmd", "/sbin/init")
+	tx.Set("lxc.autodev", "1")
+	tx.Set("lxc.mount.auto", "proc:mixed sys:ro cgroup:mixed")
+	tx.Set("lxc.tty.max", "0")
+	tx.Set("lxc.pty.max", "1024")
+	tx.Set("lxc.cap.drop", "sys_module mac_admin mac_override sys_time")
+	return nil
+}
+
+// Writes tar entries owned by the container's root, keeping the first error
+type templateWriter struct {
+	tw      *tar.Writer
+	modTime time.Time
+	// directories already written
+	dirs map[string]bool
+	err  error
+}
+
+func (w *templateWriter) write(hdr *tar.Header, r io.Reader) {
+	if w.err != nil {
+		return
+	}
+	hdr.ModTime = w.modTime
+	if w.err = w.tw.WriteHeader(hdr); w.err != nil || r == nil {
+		return
+	}
+	_, w.err = io.Copy(w.tw, r)
+}
+
+// Writes dir and its missing parents
+func (w *templateWriter) dir(dir string, mode int64) {
+	if w.dirs == nil {
+		w.dirs = make(map[string]bool)
+	}
+	if dir == "." || w.dirs[dir] {
+		return
+	}
+	w.dir(path.Dir(dir), 0755)
+	w.dirs[dir] = true
+	w.write(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: mode}, nil)
+}
+
+func (w *templateWriter) file(name string, content string, mode int64) {
+	w.write(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: int64(len(content))}, strings.NewReader(content))
+}
+
+func (w *templateWriter) symlink(name string, target string) {
+	w.write(&tar.Header{Typeflag: tar.TypeSymlink, Name: name, Linkname: target, Mode: 0777}, nil)
+}
+
+// Writes the host file src, following symlinks, as name
+func (w *templateWriter) copyFile(name string, src string, mode int64) {
+	if w.err != nil {
+		return
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		w.err = err
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		w.err = err
+		return
+	}
+	w.dir(path.Dir(name), 0755)
+	w.write(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: fi.Size()}, f)
+}
+
+// Returns the host paths of the dynamic loader and of the shared libraries the
+// ELF binary at name needs, recursively. Static binaries need none.
+func sharedLibraries(name string) ([]string, error) {
+	binary, err := elf.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer binary.Close()
+
+	var interp string
+	for _, prog := range binary.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+		data, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return nil, err
+		}
+		interp = string(bytes.TrimRight(data, "\x00"))
+	}
+	if interp == "" {
+		return nil, nil
+	}
+
+	dirs := append([]string{filepath.Dir(interp)}, libraryDirs...)
+	if multiarch, err := filepath.Glob("/usr/lib/*-linux-*"); err == nil {
+		for _, dir := range multiarch {
+			dirs = append(dirs, dir, filepath.Join("/lib", filepath.Base(dir)))
+		}
+	}
+
+	libraries := []string{interp}
+	seen := map[string]bool{path.Base(interp): true}
+	queue := []*elf.File{binary}
+	for len(queue) > 0 {
+		needed, err := queue[0].ImportedLibraries()
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+
+		for _, soname := range needed {
+			if seen[soname] {
+				continue
+			}
+			seen[soname] = true
+			library, f, err := findLibrary(soname, dirs, binary)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			libraries = append(libraries, library)
+			queue = append(queue, f)
+		}
+	}
+	return libraries, nil
+}
+
+// Looks for soname in dirs, skipping libraries built for other machines than
+// binary
+func findLibrary(soname string, dirs []string, binary *elf.File) (string, *elf.File, error) {
+	for _, dir := range dirs {
+		library := filepath.Join(dir, soname)
+		f, err := elf.Open(library)
+		if err != nil {
+			continue
+		}
+		if f.Class == binary.Class && f.Machine == binary.Machine {
+			return library, f, nil
+		}
+		f.Close()
+	}
+	return "", nil, fmt.Errorf("%w: shared library %s not found", ErrNotSupported, soname)
+}
@@ -11,6 +11,8 @@ package lxc
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 type ByteSize float64
@@ -48,3 +50,44 @@ func (b ByteSize) String() string {
 	}
 	return fmt.Sprintf("%.2fB", b)
 }
+
+var byteSizeSuffixes = map[string]ByteSize{
+	"":  1,
+	"B": 1,
+	"K": KB,
+	"M": MB,
+	"G": GB,
+	"T": TB,
+	"P": PB,
+	"E": EB,
+}
+
+// Parses sizes like 1024, 512K, 512KB or 1.5GiB. As in cgroup and LXC
+// config values, all suffixes are binary multiples.
+func ParseByteSize(s string) (ByteSize, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+
+	i := strings.IndexFunc(str, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i == -1 {
+		i = len(str)
+	}
+
+	unit := strings.TrimSpace(str[i:])
+	if len(unit) == 3 && strings.HasSuffix(unit, "IB") {
+		unit = unit[:1]
+	} else if len(unit) == 2 && strings.HasSuffix(unit, "B") {
+		unit = unit[:1]
+	}
+
+	multiplier, ok := byteSizeSuffixes[unit]
+	if !ok {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidByteSize, s)
+	}
+	value, err := strconv.ParseFloat(str[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidByteSize, s)
+	}
+	return ByteSize(value) * multiplier, nil
+}
@@ -0,0 +1,361 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type CgroupVersion int
+
+const (
+	CGROUP_V1 CgroupVersion = iota + 1
+	CGROUP_V2
+	CGROUP_HYBRID
+)
+
+const (
+	cgroupRoot        = "/sys/fs/cgroup"
+	cgroup2SuperMagic = 0x63677270
+
+	// USER_HZ, the unit of cpuacct.stat
+	userHZ = 100
+)
+
+var (
+	cgroupVersionOnce sync.Once
+	cgroupVersion     CgroupVersion
+)
+
+// CgroupVersion as string
+func (v CgroupVersion) String() string {
+	switch v {
+	case CGROUP_V1:
+		return "v1"
+	case CGROUP_V2:
+		return "v2"
+	case CGROUP_HYBRID:
+		return "hybrid"
+	}
+	return "<INVALID>"
+}
+
+// Returns the cgroup layout of the host: v1 (legacy), v2 (unified) or hybrid,
+// where the controllers are on v1 and only the process tracking uses v2.
+func DetectCgroupVersion() CgroupVersion {
+	cgroupVersionOnce.Do(func() {
+		cgroupVersion = CGROUP_V1
+		if isCgroup2(cgroupRoot) {
+			cgroupVersion = CGROUP_V2
+		} else if isCgroup2(cgroupRoot + "/unified") {
+			cgroupVersion = CGROUP_HYBRID
+		}
+	})
+	return cgroupVersion
+}
+
+func isCgroup2(path string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return false
+	}
+	return st.Type == cgroup2SuperMagic
+}
+
+// Returns whether the resource controllers live in the unified hierarchy
+func unifiedCgroup() bool {
+	return DetectCgroupVersion() == CGROUP_V2
+}
+
+// Returns the first line of the given cgroup file, liblxc returns an empty
+// string if the file doesn't exist
+func (lxc *Container) cgroupValue(key string) (string, error) {
+	value := lxc.cgroupItem(key)[0]
+	if value == "" {
+		return "", fmt.Errorf("%w: %s", ErrReadingCgroupItem, key)
+	}
+	return value, nil
+}
+
+// Parses flat keyed files like memory.stat or cpu.stat
+func (lxc *Container) cgroupStats(key string) (map[string]uint64, error) {
+	lines := lxc.cgroupItem(key)
+	if len(lines) == 1 && lines[0] == "" {
+		return nil, fmt.Errorf("%w: %s", ErrReadingCgroupItem, key)
+	}
+	return parseCgroupStats(lines)
+}
+
+func parseCgroupStats(lines []string) (map[string]uint64, error) {
+	stats := make(map[string]uint64, len(lines))
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		stats[fields[0]] = value
+	}
+	return stats, nil
+}
+
+// Parses a size in bytes, "max" (cgroup v2) meaning no limit
+func parseCgroupBytes(value string) (ByteSize, error) {
+	if value == "max" {
+		return ByteSize(math.MaxInt64), nil
+	}
+	size, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return -1, err
+	}
+	return ByteSize(size), nil
+}
+
+func (lxc *Container) cgroupBytes(key string) (ByteSize, error) {
+	value, err := lxc.cgroupValue(key)
+	if err != nil {
+		return -1, err
+	}
+	return parseCgroupBytes(value)
+}
+
+func (lxc *Container) memoryUsage() (ByteSize, error) {
+	if unifiedCgroup() {
+		return lxc.cgroupBytes("memory.current")
+	}
+	return lxc.cgroupBytes("memory.usage_in_bytes")
+}
+
+// memory.memsw.* account memory and swap together, cgroup v2 accounts swap on
+// its own so both values are added up
+func (lxc *Container) swapUsage() (ByteSize, error) {
+	if !unifiedCgroup() {
+		return lxc.cgroupBytes("memory.memsw.usage_in_bytes")
+	}
+	return lxc.addCgroupBytes("memory.current", "memory.swap.current")
+}
+
+func (lxc *Container) memoryLimit() (ByteSize, error) {
+	if unifiedCgroup() {
+		return lxc.cgroupBytes("memory.max")
+	}
+	return lxc.cgroupBytes("memory.limit_in_bytes")
+}
+
+func (lxc *Container) swapLimit() (ByteSize, error) {
+	if !unifiedCgroup() {
+		return lxc.cgroupBytes("memory.memsw.limit_in_bytes")
+	}
+	return lxc.addCgroupBytes("memory.max", "memory.swap.max")
+}
+
+func (lxc *Container) addCgroupBytes(keys ...string) (ByteSize, error) {
+	var total ByteSize
+	for _, key := range keys {
+		size, err := lxc.cgroupBytes(key)
+		if err != nil {
+			return -1, err
+		}
+		if size == ByteSize(math.MaxInt64) {
+			return size, nil
+		}
+		total += size
+	}
+	return total, nil
+}
+
+func (lxc *Container) cpuTime() (time.Duration, error) {
+	if unifiedCgroup() {
+		stats, err := lxc.cgroupStats("cpu.stat")
+		if err != nil {
+			return -1, err
+		}
+		return time.Duration(stats["usage_usec"]) * time.Microsecond, nil
+	}
+
+	value, err := lxc.cgroupValue("cpuacct.usage")
+	if err != nil {
+		return -1, err
+	}
+	cpuUsage, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1, err
+	}
+	return time.Duration(cpuUsage), nil
+}
+
+func (lxc *Container) cpuTimePerCPU() ([]time.Duration, error) {
+	if unifiedCgroup() {
+		return nil, ErrNotSupported
+	}
+
+	value, err := lxc.cgroupValue("cpuacct.usage_percpu")
+	if err != nil {
+		return nil, err
+	}
+
+	var cpuTimes []time.Duration
+	for _, v := range strings.Fields(value) {
+		cpuUsage, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		cpuTimes = append(cpuTimes, time.Duration(cpuUsage))
+	}
+	return cpuTimes, nil
+}
+
+// Returns user and system time in USER_HZ units, converting the microseconds
+// reported by cgroup v2
+func (lxc *Container) cpuStats() ([]int64, error) {
+	if unifiedCgroup() {
+		stats, err := lxc.cgroupStats("cpu.stat")
+		if err != nil {
+			return nil, err
+		}
+		return []int64{
+			int64(stats["user_usec"] * userHZ / 1000000),
+			int64(stats["system_usec"] * userHZ / 1000000),
+		}, nil
+	}
+
+	stats, err := lxc.cgroupStats("cpuacct.stat")
+	if err != nil {
+		return nil, err
+	}
+	return []int64{int64(stats["user"]), int64(stats["system"])}, nil
+}
+
+// Returns the directory of the container's top cgroup for the given v1
+// controller, or of its unified cgroup on cgroup v2 hosts. The cgroups its
+// processes moved to, like init.scope of systemd, are below it.
+func (lxc *Container) cgroupPath(controller string) (string, error) {
+	pid := lxc.initPID()
+	if pid == -1 {
+		return "", ErrNotRunning
+	}
+	if unifiedCgroup() {
+		controller = ""
+	}
+
+	cgroups, err := os.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	path, ok := procCgroupPath(string(cgroups), controller)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrCgroupNotFound, controller)
+	}
+	dir, _, _ := lxc.configValue("lxc.cgroup.dir.container")
+	if dir == "" {
+		dir, _, _ = lxc.configValue("lxc.cgroup.dir")
+	}
+	path = payloadCgroup(path, lxc.name(), dir)
+
+	mounts, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	mountPoint, root, ok := cgroupMountPoint(string(mounts), controller)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not mounted", ErrCgroupNotFound, controller)
+	}
+	if root != "/" {
+		path = strings.TrimPrefix(path, root)
+	}
+	return filepath.Join(mountPoint, path), nil
+}
+
+// Returns the container's top cgroup, path or one of its parents, given the
+// cgroup path of its init. liblxc names it lxc.payload.<name>, lxc/<name>
+// before LXC 4.0, with a -<n> suffix if the name is taken, unless dir,
+// lxc.cgroup.dir, sets it. path is returned if none of them matches.
+func payloadCgroup(path string, name string, dir string) string {
+	isName := func(base string, name string) bool {
+		suffix, ok := strings.CutPrefix(base, name)
+		if !ok {
+			return false
+		}
+		if suffix == "" {
+			return true
+		}
+		_, err := strconv.Atoi(strings.TrimPrefix(suffix, "-"))
+		return strings.HasPrefix(suffix, "-") && err == nil
+	}
+
+	dir = strings.Trim(dir, "/")
+	for p := filepath.Clean("/" + path); p != "/"; p = filepath.Dir(p) {
+		if dir != "" {
+			if p == "/"+dir || strings.HasSuffix(p, "/"+dir) {
+				return p
+			}
+			continue
+		}
+		base, parent := filepath.Base(p), filepath.Base(filepath.Dir(p))
+		if isName(base, "lxc.payload."+name) || ((parent == "lxc" || parent == "lxc.payload") && isName(base, name)) {
+			return p
+		}
+	}
+	return path
+}
+
+// Parses /proc/<pid>/cgroup lines like "4:cpu,cpuacct:/lxc/c1", an empty
+// controller selects the unified hierarchy ("0::/lxc/c1")
+func procCgroupPath(data string, controller string) (string, bool) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if controller == "" {
+			if fields[0] == "0" && fields[1] == "" {
+				return fields[2], true
+			}
+			continue
+		}
+		if containsString(strings.Split(fields[1], ","), controller) {
+			return fields[2], true
+		}
+	}
+	return "", false
+}
+
+// Returns where the hierarchy of the given controller is mounted and which
+// cgroup is mounted there, from the contents of /proc/self/mountinfo
+func cgroupMountPoint(data string, controller string) (string, string, bool) {
+	mounts, err := parseMountInfo(data)
+	if err != nil {
+		return "", "", false
+	}
+
+	for _, m := range mounts {
+		if controller == "" {
+			if m.FSType == "cgroup2" {
+				return m.MountPoint, m.Root, true
+			}
+			continue
+		}
+		if m.FSType == "cgroup" && containsString(m.SuperOptions, controller) {
+			return m.MountPoint, m.Root, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,104 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+// #include <lxc/lxc.h>
+// #include <lxc/lxccontainer.h>
+// #include "lxc.h"
+import "C"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Returns the value of the given key and whether it is set. Unlike ConfigItem,
+// an error is returned if liblxc fails to look the key up.
+func (lxc *Container) ConfigValue(key string) (string, bool, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	return lxc.configValue(key)
+}
+
+// Returns the value of the given key parsed as an integer
+func (lxc *Container) ConfigInt(key string) (int64, bool, error) {
+	value, ok, err := lxc.ConfigValue(key)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, true, configValueError(key, value)
+	}
+	return i, true, nil
+}
+
+// Returns the value of the given key parsed as a boolean
+func (lxc *Container) ConfigBool(key string) (bool, bool, error) {
+	value, ok, err := lxc.ConfigValue(key)
+	if err != nil || !ok {
+		return false, ok, err
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, true, configValueError(key, value)
+	}
+	return b, true, nil
+}
+
+// Returns the values of the given multi-valued key (like lxc.cap.drop)
+func (lxc *Container) ConfigList(key string) ([]string, bool, error) {
+	value, ok, err := lxc.ConfigValue(key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+
+	var values []string
+	for _, v := range strings.Split(value, "\n") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, true, nil
+}
+
+// Returns the value of the given key parsed as a ByteSize (like 512M)
+func (lxc *Container) ConfigByteSize(key string) (ByteSize, bool, error) {
+	value, ok, err := lxc.ConfigValue(key)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return 0, true, configValueError(key, value)
+	}
+	return size, true, nil
+}
+
+func (lxc *Container) configValue(key string) (string, bool, error) {
+	ckey := C.CString(translateConfigKey(key))
+	defer C.free(unsafe.Pointer(ckey))
+
+	// allocated in lxc.c, NULL if the lookup failed
+	configItem := C.lxc_container_get_config_item(lxc.container, ckey)
+	if configItem == nil {
+		return "", false, fmt.Errorf("%w: %s", ErrInvalidConfigKey, key)
+	}
+	defer C.free(unsafe.Pointer(configItem))
+
+	value := strings.TrimSpace(C.GoString(configItem))
+	return value, value != "", nil
+}
+
+func configValueError(key string, value string) error {
+	return fmt.Errorf("%w: %s = %q", ErrInvalidConfigValue, key, value)
+}
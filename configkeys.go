@@ -0,0 +1,292 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LXC 2.1 renamed most of the configuration keys, legacy keys are mapped to
+// their modern spelling here
+var legacyConfigKeys = map[string]string{
+	"lxc.aa_allow_incomplete": "lxc.apparmor.allow_incomplete",
+	"lxc.aa_profile":          "lxc.apparmor.profile",
+	"lxc.console":             "lxc.console.path",
+	"lxc.devttydir":           "lxc.tty.dir",
+	"lxc.haltsignal":          "lxc.signal.halt",
+	"lxc.id_map":              "lxc.idmap",
+	"lxc.init_cmd":            "lxc.init.cmd",
+	"lxc.init_gid":            "lxc.init.gid",
+	"lxc.init_uid":            "lxc.init.uid",
+	"lxc.limit":               "lxc.prlimit",
+	"lxc.logfile":             "lxc.log.file",
+	"lxc.loglevel":            "lxc.log.level",
+	"lxc.mount":               "lxc.mount.fstab",
+	"lxc.network":             "lxc.net",
+	"lxc.pts":                 "lxc.pty.max",
+	"lxc.rebootsignal":        "lxc.signal.reboot",
+	"lxc.rootfs":              "lxc.rootfs.path",
+	"lxc.se_context":          "lxc.selinux.context",
+	"lxc.seccomp":             "lxc.seccomp.profile",
+	"lxc.stopsignal":          "lxc.signal.stop",
+	"lxc.syslog":              "lxc.log.syslog",
+	"lxc.tty":                 "lxc.tty.max",
+	"lxc.utsname":             "lxc.uts.name",
+}
+
+// network keys that were renamed in addition to lxc.network.N -> lxc.net.N
+var legacyNetworkKeys = map[string]string{
+	"ipv4": "ipv4.address",
+	"ipv6": "ipv6.address",
+}
+
+var modernConfigKeys = invertKeys(legacyConfigKeys)
+var modernNetworkKeys = invertKeys(legacyNetworkKeys)
+
+var (
+	modernKeysOnce sync.Once
+	modernKeys     bool
+)
+
+func invertKeys(keys map[string]string) map[string]string {
+	inverted := make(map[string]string, len(keys))
+	for k, v := range keys {
+		inverted[v] = k
+	}
+	return inverted
+}
+
+// Returns whether the liblxc in use expects the keys introduced in LXC 2.1
+func usesModernConfigKeys() bool {
+	modernKeysOnce.Do(func() {
+		modernKeys = versionAtLeast(Version(), 2, 1)
+	})
+	return modernKeys
+}
+
+func versionAtLeast(version string, major int, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	vmajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	vminor, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool {
+		return r < '0' || r > '9'
+	}))
+	if err != nil {
+		return false
+	}
+	return vmajor > major || (vmajor == major && vminor >= minor)
+}
+
+// Translates the given key into the spelling the liblxc in use understands,
+// so that callers can use either of them
+func translateConfigKey(key string) string {
+	if usesModernConfigKeys() {
+		return modernConfigKey(key)
+	}
+	return legacyConfigKey(key)
+}
+
+// Returns the LXC 2.1+ spelling of the given key. Unindexed lxc.network.*
+// keys belong to the first interface, MigrateConfig numbers the ones of a
+// whole file.
+func modernConfigKey(key string) string {
+	if k, ok := legacyConfigKeys[key]; ok {
+		return k
+	}
+	if rest, ok := strings.CutPrefix(key, "lxc.limit."); ok {
+		return "lxc.prlimit." + rest
+	}
+	if rest, ok := strings.CutPrefix(key, "lxc.network."); ok {
+		if !startsWithIndex(rest) {
+			rest = "0." + rest
+		}
+		return "lxc.net." + renameNetworkKey(rest, legacyNetworkKeys)
+	}
+	return key
+}
+
+// Returns the pre LXC 2.1 spelling of the given key
+func legacyConfigKey(key string) string {
+	if k, ok := modernConfigKeys[key]; ok {
+		return k
+	}
+	if rest, ok := strings.CutPrefix(key, "lxc.prlimit."); ok {
+		return "lxc.limit." + rest
+	}
+	if rest, ok := strings.CutPrefix(key, "lxc.net."); ok {
+		return "lxc.network." + renameNetworkKey(rest, modernNetworkKeys)
+	}
+	return key
+}
+
+// renames the part of an indexed network key following the index
+func renameNetworkKey(key string, renames map[string]string) string {
+	index, name, ok := strings.Cut(key, ".")
+	if !ok {
+		return key
+	}
+	if _, err := strconv.Atoi(index); err != nil {
+		return key
+	}
+	if n, ok := renames[name]; ok {
+		name = n
+	}
+	return index + "." + name
+}
+
+// A single line rewritten by MigrateConfig
+type ConfigChange struct {
+	Line int
+	Old  string
+	New  string
+}
+
+// The result of migrating a configuration file to the LXC 2.1+ keys
+type ConfigMigration struct {
+	Path    string
+	Changes []ConfigChange
+}
+
+// Returns the changes in unified diff format
+func (m *ConfigMigration) Diff() string {
+	var buf bytes.Buffer
+
+	if len(m.Changes) == 0 {
+		return ""
+	}
+	fmt.Fprintf(&buf, "--- %s\n+++ %s\n", m.Path, m.Path)
+	for _, change := range m.Changes {
+		fmt.Fprintf(&buf, "@@ -%d +%d @@\n-%s\n+%s\n", change.Line, change.Line, change.Old, change.New)
+	}
+	return buf.String()
+}
+
+// Rewrites the legacy keys in the given configuration file to their LXC 2.1+
+// spelling. Unindexed lxc.network.* keys are numbered, starting a new
+// interface at each lxc.network.type as older LXC versions did. If dryRun is
+// set the file is left untouched and only the report is returned.
+func MigrateConfig(path string, dryRun bool) (*ConfigMigration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	migrated, changes := migrateConfig(data)
+	migration := &ConfigMigration{Path: path, Changes: changes}
+	if dryRun || len(changes) == 0 {
+		return migration, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, migrated, info.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	return migration, nil
+}
+
+// Returns the configuration file data with the legacy keys in their LXC 2.1+
+// spelling, numbered like MigrateConfig does
+func ModernConfig(data []byte) []byte {
+	migrated, _ := migrateConfig(data)
+	return migrated
+}
+
+func migrateConfig(data []byte) ([]byte, []ConfigChange) {
+	var buf bytes.Buffer
+	var changes []ConfigChange
+
+	network := -1
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+
+		key, _, ok := parseConfigLine(line)
+		if ok {
+			indexed := key
+			if rest, ok := strings.CutPrefix(key, "lxc.network."); ok && !startsWithIndex(rest) {
+				// lxc.network.type starts a new interface
+				if rest == "type" || network == -1 {
+					network++
+				}
+				indexed = fmt.Sprintf("lxc.network.%d.%s", network, rest)
+			}
+			newKey := modernConfigKey(indexed)
+
+			if newKey != key {
+				newLine := strings.Replace(line, key, newKey, 1)
+				changes = append(changes, ConfigChange{Line: n, Old: line, New: newLine})
+				line = newLine
+			}
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), changes
+}
+
+// Splits a "key = value" line, comments and blank lines are skipped
+func parseConfigLine(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false
+	}
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return "", "", false
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value), true
+}
+
+func startsWithIndex(key string) bool {
+	index, _, _ := strings.Cut(key, ".")
+	_, err := strconv.Atoi(index)
+	return err == nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
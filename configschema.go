@@ -0,0 +1,537 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type configKind int
+
+const (
+	kindString configKind = iota
+	kindInt
+	kindBool
+	kindByteSize
+	kindEnum
+	kindTokens
+	kindSignal
+	kindIDMap
+	kindHWAddr
+	kindIPv4
+	kindIPv6
+)
+
+type configKeySpec struct {
+	kind   configKind
+	values []string
+	// enum values are matched case-insensitively, like liblxc's strcasecmp
+	foldCase bool
+}
+
+// Known configuration keys in their LXC 2.1+ spelling, network keys use * in
+// place of the interface index
+var configSchema = map[string]configKeySpec{
+	"lxc.arch": {kind: kindEnum, values: []string{
+		"x86", "i386", "i486", "i586", "i686", "athlon", "x86_64", "amd64",
+		"arm", "armhf", "armel", "armv7l", "arm64", "aarch64",
+		"ppc", "powerpc", "ppc64", "powerpc64", "ppc64el", "ppc64le", "s390x",
+		"mips", "mipsel", "mips64", "mips64el", "riscv64", "linux32", "linux64",
+	}},
+	"lxc.apparmor.allow_incomplete": {kind: kindBool},
+	"lxc.apparmor.allow_nesting":    {kind: kindBool},
+	"lxc.apparmor.profile":          {kind: kindString},
+	"lxc.apparmor.raw":              {kind: kindString},
+	"lxc.autodev":                   {kind: kindBool},
+	"lxc.cap.drop":                  {kind: kindString},
+	"lxc.cap.keep":                  {kind: kindString},
+	"lxc.cgroup.dir":                {kind: kindString},
+	"lxc.console.buffer.size":       {kind: kindString},
+	"lxc.console.logfile":           {kind: kindString},
+	"lxc.console.path":              {kind: kindString},
+	"lxc.console.rotate":            {kind: kindBool},
+	"lxc.console.size":              {kind: kindString},
+	"lxc.environment":               {kind: kindString},
+	"lxc.ephemeral":                 {kind: kindBool},
+	"lxc.group":                     {kind: kindString},
+	"lxc.hook.autodev":              {kind: kindString},
+	"lxc.hook.clone":                {kind: kindString},
+	"lxc.hook.destroy":              {kind: kindString},
+	"lxc.hook.mount":                {kind: kindString},
+	"lxc.hook.post-stop":            {kind: kindString},
+	"lxc.hook.pre-mount":            {kind: kindString},
+	"lxc.hook.pre-start":            {kind: kindString},
+	"lxc.hook.start":                {kind: kindString},
+	"lxc.hook.start-host":           {kind: kindString},
+	"lxc.hook.stop":                 {kind: kindString},
+	"lxc.hook.version":              {kind: kindEnum, values: []string{"0", "1"}},
+	"lxc.idmap":                     {kind: kindIDMap},
+	"lxc.include":                   {kind: kindString},
+	"lxc.init.cmd":                  {kind: kindString},
+	"lxc.init.cwd":                  {kind: kindString},
+	"lxc.init.gid":                  {kind: kindInt},
+	"lxc.init.uid":                  {kind: kindInt},
+	"lxc.log.file":                  {kind: kindString},
+	"lxc.log.level": {kind: kindEnum, foldCase: true, values: []string{
+		"0", "1", "2", "3", "4", "5", "6", "7", "8",
+		"TRACE", "DEBUG", "INFO", "NOTICE", "WARN", "ERROR", "CRIT", "ALERT", "FATAL",
+	}},
+	"lxc.log.syslog":      {kind: kindString},
+	"lxc.monitor.unshare": {kind: kindBool},
+	"lxc.namespace.clone": {kind: kindString},
+	"lxc.namespace.keep":  {kind: kindString},
+	"lxc.mount.auto": {kind: kindTokens, values: []string{
+		"proc", "proc:mixed", "proc:rw",
+		"sys", "sys:mixed", "sys:ro", "sys:rw",
+		"cgroup", "cgroup:mixed", "cgroup:ro", "cgroup:rw",
+		"cgroup:mixed:force", "cgroup:ro:force", "cgroup:rw:force", "cgroup:force",
+		"cgroup-full", "cgroup-full:mixed", "cgroup-full:ro", "cgroup-full:rw",
+		"cgroup-full:mixed:force", "cgroup-full:ro:force", "cgroup-full:rw:force", "cgroup-full:force",
+		// followed by "<host path>[:<container path>]"
+		"shmounts:",
+	}},
+	"lxc.mount.entry":           {kind: kindString},
+	"lxc.mount.fstab":           {kind: kindString},
+	"lxc.net":                   {kind: kindString},
+	"lxc.net.*.flags":           {kind: kindEnum, values: []string{"up"}},
+	"lxc.net.*.hwaddr":          {kind: kindHWAddr},
+	"lxc.net.*.ipv4.address":    {kind: kindIPv4},
+	"lxc.net.*.ipv4.gateway":    {kind: kindIPv4},
+	"lxc.net.*.ipv6.address":    {kind: kindIPv6},
+	"lxc.net.*.ipv6.gateway":    {kind: kindIPv6},
+	"lxc.net.*.link":            {kind: kindString},
+	"lxc.net.*.macvlan.mode":    {kind: kindEnum, values: []string{"private", "vepa", "bridge", "passthru"}},
+	"lxc.net.*.mtu":             {kind: kindInt},
+	"lxc.net.*.name":            {kind: kindString},
+	"lxc.net.*.script.down":     {kind: kindString},
+	"lxc.net.*.script.up":       {kind: kindString},
+	"lxc.net.*.type":            {kind: kindEnum, values: []string{"empty", "veth", "vlan", "macvlan", "ipvlan", "phys", "none"}},
+	"lxc.net.*.veth.pair":       {kind: kindString},
+	"lxc.net.*.vlan.id":         {kind: kindInt},
+	"lxc.no_new_privs":          {kind: kindBool},
+	"lxc.pty.max":               {kind: kindInt},
+	"lxc.rootfs.mount":          {kind: kindString},
+	"lxc.rootfs.options":        {kind: kindString},
+	"lxc.rootfs.path":           {kind: kindString},
+	"lxc.seccomp.allow_nesting": {kind: kindBool},
+	"lxc.seccomp.profile":       {kind: kindString},
+	"lxc.selinux.context":       {kind: kindString},
+	"lxc.signal.halt":           {kind: kindSignal},
+	"lxc.signal.reboot":         {kind: kindSignal},
+	"lxc.signal.stop":           {kind: kindSignal},
+	"lxc.start.auto":            {kind: kindBool},
+	"lxc.start.delay":           {kind: kindInt},
+	"lxc.start.order":           {kind: kindInt},
+	"lxc.start.timeout":         {kind: kindInt},
+	"lxc.tty.dir":               {kind: kindString},
+	"lxc.tty.max":               {kind: kindInt},
+	"lxc.uts.name":              {kind: kindString},
+}
+
+// keys whose suffix is passed through to the kernel or liblxc untouched
+var configSchemaPrefixes = []string{
+	"lxc.cgroup.",
+	"lxc.cgroup2.",
+	"lxc.namespace.share.",
+	"lxc.prlimit.",
+	"lxc.proc.",
+	"lxc.sysctl.",
+}
+
+// cgroup values that are sizes in bytes
+var byteSizeCgroupKeys = map[string]bool{
+	"memory.limit_in_bytes":       true,
+	"memory.memsw.limit_in_bytes": true,
+	"memory.soft_limit_in_bytes":  true,
+	"memory.kmem.limit_in_bytes":  true,
+}
+
+type ConfigSeverity int
+
+const (
+	CONFIG_WARNING ConfigSeverity = iota
+	CONFIG_ERROR
+)
+
+// ConfigSeverity as string
+func (s ConfigSeverity) String() string {
+	switch s {
+	case CONFIG_WARNING:
+		return "warning"
+	case CONFIG_ERROR:
+		return "error"
+	}
+	return "<INVALID>"
+}
+
+// A problem found by ValidateConfig
+type ConfigDiagnostic struct {
+	Path     string
+	Line     int
+	Key      string
+	Severity ConfigSeverity
+	Message  string
+}
+
+// Formats the diagnostic as path:line: severity: key: message
+func (d ConfigDiagnostic) String() string {
+	return fmt.Sprintf("%s:%d: %s: %s: %s", d.Path, d.Line, d.Severity, d.Key, d.Message)
+}
+
+// Checks the given configuration file against the known configuration keys and
+// returns the problems found, ordered by line. Files pulled in by lxc.include
+// are not followed.
+func ValidateConfig(path string) ([]ConfigDiagnostic, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	v := &configValidator{
+		path:     path,
+		networks: make(map[string]*networkConfig),
+	}
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+
+		key, value, ok := parseConfigLine(line)
+		if !ok {
+			v.report(n, "", CONFIG_ERROR, "line is not in key = value format")
+			continue
+		}
+		v.check(n, key, value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	v.checkCombinations()
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		return v.diagnostics[i].Line < v.diagnostics[j].Line
+	})
+	return v.diagnostics, nil
+}
+
+// Returns an error if the value is obviously invalid for a known key. Unknown
+// keys are left to liblxc.
+func validateConfigItem(key string, value string) error {
+	if value == "" {
+		// empty values clear keys
+		return nil
+	}
+	spec, pattern, ok := lookupConfigKey(modernConfigKey(key))
+	if !ok {
+		return nil
+	}
+	if msg := checkConfigValue(pattern, spec, value); msg != "" {
+		return fmt.Errorf("%w: %s = %q: %s", ErrInvalidConfigValue, key, value, msg)
+	}
+	return nil
+}
+
+// network interface related state collected while validating
+type networkConfig struct {
+	line    int
+	netType string
+	link    bool
+	vlanID  bool
+}
+
+type configValidator struct {
+	path        string
+	diagnostics []ConfigDiagnostic
+
+	networks   map[string]*networkConfig
+	network    int
+	capDrop    int
+	capKeep    int
+	uidMaps    int
+	gidMaps    int
+	rootfsPath bool
+}
+
+func (v *configValidator) report(line int, key string, severity ConfigSeverity, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, ConfigDiagnostic{
+		Path:     v.path,
+		Line:     line,
+		Key:      key,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *configValidator) check(line int, key string, value string) {
+	modern := modernConfigKey(key)
+
+	// legacy unindexed network keys describe the last lxc.network.type
+	if rest, ok := strings.CutPrefix(key, "lxc.network."); ok && !startsWithIndex(rest) {
+		if rest == "type" || v.network == 0 {
+			v.network++
+		}
+		modern = modernConfigKey(fmt.Sprintf("lxc.network.%d.%s", v.network-1, rest))
+	}
+	if modern != key {
+		v.report(line, key, CONFIG_WARNING, "deprecated, use %s", modern)
+	}
+
+	spec, pattern, ok := lookupConfigKey(modern)
+	if !ok {
+		// may be a key of a newer liblxc
+		v.report(line, key, CONFIG_WARNING, "unknown key")
+		return
+	}
+	if value != "" {
+		if msg := checkConfigValue(pattern, spec, value); msg != "" {
+			v.report(line, key, CONFIG_ERROR, "%s", msg)
+		}
+	}
+	v.collect(line, modern, value)
+}
+
+// records the values needed by checkCombinations
+func (v *configValidator) collect(line int, key string, value string) {
+	switch key {
+	case "lxc.cap.drop":
+		v.capDrop++
+	case "lxc.cap.keep":
+		v.capKeep++
+	case "lxc.rootfs.path":
+		v.rootfsPath = value != ""
+	case "lxc.idmap":
+		if strings.HasPrefix(value, "u") {
+			v.uidMaps++
+		} else if strings.HasPrefix(value, "g") {
+			v.gidMaps++
+		}
+	}
+
+	rest, ok := strings.CutPrefix(key, "lxc.net.")
+	if !ok {
+		return
+	}
+	index, name, ok := strings.Cut(rest, ".")
+	if !ok {
+		return
+	}
+	network, ok := v.networks[index]
+	if !ok {
+		network = &networkConfig{line: line}
+		v.networks[index] = network
+	}
+	switch name {
+	case "type":
+		network.netType = value
+	case "link":
+		network.link = value != ""
+	case "vlan.id":
+		network.vlanID = value != ""
+	}
+}
+
+func (v *configValidator) checkCombinations() {
+	if v.capDrop > 0 && v.capKeep > 0 {
+		v.report(0, "lxc.cap.keep", CONFIG_ERROR, "lxc.cap.drop and lxc.cap.keep can't be used together")
+	}
+	if (v.uidMaps > 0) != (v.gidMaps > 0) {
+		v.report(0, "lxc.idmap", CONFIG_ERROR, "both uid and gid mappings are needed")
+	}
+	if !v.rootfsPath {
+		v.report(0, "lxc.rootfs.path", CONFIG_WARNING, "no root filesystem is configured")
+	}
+
+	for index, network := range v.networks {
+		key := "lxc.net." + index
+		switch network.netType {
+		case "":
+			v.report(network.line, key, CONFIG_ERROR, "network has no type")
+		case "veth":
+			if !network.link {
+				v.report(network.line, key, CONFIG_WARNING, "veth network has no link, it won't be attached to a bridge")
+			}
+		case "macvlan", "vlan", "ipvlan", "phys":
+			if !network.link {
+				v.report(network.line, key, CONFIG_ERROR, "%s network needs a link", network.netType)
+			}
+		}
+		if network.netType == "vlan" && !network.vlanID {
+			v.report(network.line, key, CONFIG_ERROR, "vlan network needs a vlan.id")
+		}
+	}
+}
+
+// Returns the schema entry of the given LXC 2.1+ key and the pattern it matched
+func lookupConfigKey(key string) (configKeySpec, string, bool) {
+	if spec, ok := configSchema[key]; ok {
+		return spec, key, true
+	}
+	if rest, ok := strings.CutPrefix(key, "lxc.net."); ok {
+		if index, name, ok := strings.Cut(rest, "."); ok {
+			if _, err := strconv.Atoi(index); err == nil {
+				pattern := "lxc.net.*." + name
+				spec, ok := configSchema[pattern]
+				return spec, pattern, ok
+			}
+		}
+	}
+	for _, prefix := range configSchemaPrefixes {
+		if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+			if (prefix == "lxc.cgroup." || prefix == "lxc.cgroup2.") && byteSizeCgroupKeys[key[len(prefix):]] {
+				return configKeySpec{kind: kindByteSize}, key, true
+			}
+			return configKeySpec{kind: kindString}, key, true
+		}
+	}
+	return configKeySpec{}, "", false
+}
+
+// Returns a description of the problem or an empty string if the value is valid
+func checkConfigValue(key string, spec configKeySpec, value string) string {
+	switch spec.kind {
+	case kindInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "not an integer"
+		}
+	case kindBool:
+		if value != "0" && value != "1" {
+			return "must be 0 or 1"
+		}
+	case kindByteSize:
+		if value == "-1" || value == "max" {
+			return ""
+		}
+		if _, err := ParseByteSize(value); err != nil {
+			return "not a size"
+		}
+	case kindEnum:
+		if spec.foldCase {
+			value = strings.ToUpper(value)
+		}
+		if !containsString(spec.values, value) {
+			return fmt.Sprintf("must be one of %s", strings.Join(spec.values, ", "))
+		}
+	case kindTokens:
+		for _, token := range strings.Fields(value) {
+			if !containsString(spec.values, token) && !hasTokenPrefix(spec.values, token) {
+				return fmt.Sprintf("unknown option %q", token)
+			}
+		}
+	case kindSignal:
+		if _, err := strconv.Atoi(value); err != nil && !isSignalName(value) {
+			return "not a signal"
+		}
+	case kindIDMap:
+		return checkIDMap(value)
+	case kindHWAddr:
+		return checkHWAddr(value)
+	case kindIPv4, kindIPv6:
+		return checkIPAddress(value, spec.kind == kindIPv4, strings.HasSuffix(key, ".gateway"))
+	}
+	return ""
+}
+
+// Matches tokens against values ending with ":", which take arguments
+func hasTokenPrefix(values []string, token string) bool {
+	for _, v := range values {
+		if strings.HasSuffix(v, ":") && strings.HasPrefix(token, v) && len(token) > len(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+var signalNames = []string{
+	"HUP", "INT", "QUIT", "ILL", "TRAP", "ABRT", "BUS", "FPE", "KILL", "USR1",
+	"SEGV", "USR2", "PIPE", "ALRM", "TERM", "STKFLT", "CHLD", "CONT", "STOP",
+	"TSTP", "TTIN", "TTOU", "URG", "XCPU", "XFSZ", "VTALRM", "PROF", "WINCH",
+	"IO", "POLL", "PWR", "SYS",
+}
+
+func isSignalName(value string) bool {
+	name := strings.TrimPrefix(strings.ToUpper(value), "SIG")
+	if strings.HasPrefix(name, "RTMIN") || strings.HasPrefix(name, "RTMAX") {
+		return true
+	}
+	return containsString(signalNames, name)
+}
+
+// checks "u|g <container id> <host id> <range>"
+func checkIDMap(value string) string {
+	fields := strings.Fields(value)
+	if len(fields) != 4 || (fields[0] != "u" && fields[0] != "g" && fields[0] != "b") {
+		return "must be in \"u|g containerid hostid range\" format"
+	}
+	for _, field := range fields[1:] {
+		if _, err := strconv.ParseUint(field, 10, 32); err != nil {
+			return "must be in \"u|g containerid hostid range\" format"
+		}
+	}
+	return ""
+}
+
+// checks a MAC address, LXC replaces x characters with random digits
+func checkHWAddr(value string) string {
+	parts := strings.Split(value, ":")
+	if len(parts) != 6 {
+		return "not a MAC address"
+	}
+	for _, part := range parts {
+		if len(part) != 2 || strings.Trim(strings.ToLower(part), "0123456789abcdefx") != "" {
+			return "not a MAC address"
+		}
+	}
+	return ""
+}
+
+// checks "address[/prefix] [broadcast]" or, for gateways, "address|auto|dev"
+func checkIPAddress(value string, ipv4 bool, gateway bool) string {
+	fields := strings.Fields(value)
+	if gateway && len(fields) == 1 && (fields[0] == "auto" || fields[0] == "dev") {
+		return ""
+	}
+	if len(fields) == 0 || len(fields) > 2 || (gateway && len(fields) != 1) {
+		return "not an address"
+	}
+
+	for i, field := range fields {
+		address := field
+		if i == 0 && !gateway {
+			address, _, _ = strings.Cut(field, "/")
+			if _, _, err := net.ParseCIDR(field); strings.Contains(field, "/") && err != nil {
+				return "invalid prefix length"
+			}
+		}
+		ip := net.ParseIP(address)
+		if ip == nil || (ip.To4() != nil) != ipv4 {
+			return "not an address"
+		}
+	}
+	return ""
+}
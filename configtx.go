@@ -0,0 +1,184 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigTx collects a batch of configuration changes for EditConfig
+type ConfigTx struct {
+	ops []configOp
+}
+
+type configOp struct {
+	key   string
+	value string
+	clear bool
+}
+
+// Sets the value of given key when the transaction is committed
+func (tx *ConfigTx) Set(key string, value string) {
+	tx.ops = append(tx.ops, configOp{key: key, value: value})
+}
+
+// Clears the value of given key when the transaction is committed
+func (tx *ConfigTx) Clear(key string) {
+	tx.ops = append(tx.ops, configOp{key: key, clear: true})
+}
+
+// previous value of a key, used for rolling back
+type configBackup struct {
+	key    string
+	values []string
+}
+
+// Applies the changes collected by fn and atomically saves the configuration
+// file. If fn, one of the changes or saving the file fails, the in-memory
+// configuration is restored and the file is left untouched.
+//
+// fn is called without holding the container lock, so it may read the
+// current configuration using the container's methods.
+func (lxc *Container) EditConfig(fn func(tx *ConfigTx) error) error {
+	tx := &ConfigTx{}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.validate(); err != nil {
+		return err
+	}
+
+	lxc.mu.Lock()
+	defer lxc.mu.Unlock()
+	return lxc.commitConfig(tx, true)
+}
+
+// Applies the validated changes of tx and, if save is set, saves the
+// configuration file. The caller holds the lock.
+func (lxc *Container) commitConfig(tx *ConfigTx, save bool) error {
+	var backups []configBackup
+	seen := make(map[string]bool)
+
+	for _, op := range tx.ops {
+		if !seen[op.key] {
+			backups = append(backups, lxc.backupConfigItem(op.key))
+			seen[op.key] = true
+		}
+
+		if op.clear {
+			if !lxc.clearConfigItem(op.key) {
+				return errors.Join(fmt.Errorf("%w: %s", ErrClearingConfigItem, op.key), lxc.restoreConfig(backups))
+			}
+			continue
+		}
+		if !lxc.setConfigItem(op.key, op.value) {
+			return errors.Join(fmt.Errorf("%w: %s = %q", ErrSettingConfigItem, op.key, op.value), lxc.restoreConfig(backups))
+		}
+	}
+
+	if !save {
+		return nil
+	}
+	if err := lxc.saveConfigAtomic(lxc.configFileName()); err != nil {
+		return errors.Join(err, lxc.restoreConfig(backups))
+	}
+	return nil
+}
+
+func (tx *ConfigTx) validate() error {
+	for _, op := range tx.ops {
+		if op.key == "" || strings.ContainsAny(op.key, " \t\n=") {
+			return fmt.Errorf("%w: %q", ErrInvalidConfigKey, op.key)
+		}
+		if strings.Contains(op.value, "\n") {
+			return configValueError(op.key, op.value)
+		}
+		if !op.clear {
+			if err := validateConfigItem(op.key, op.value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (lxc *Container) backupConfigItem(key string) configBackup {
+	backup := configBackup{key: key}
+
+	// keys that can't be read back (like the ones of a network interface
+	// that doesn't exist yet) are restored by clearing them
+	if value, ok, err := lxc.configValue(key); err == nil && ok {
+		backup.values = strings.Split(value, "\n")
+	}
+	return backup
+}
+
+// restores the backed up keys in reverse order, multi-valued keys are cleared
+// before their previous values are set again
+func (lxc *Container) restoreConfig(backups []configBackup) error {
+	var errs []error
+
+	for i := len(backups) - 1; i >= 0; i-- {
+		backup := backups[i]
+
+		cleared := lxc.clearConfigItem(backup.key)
+		if len(backup.values) == 0 {
+			if !cleared {
+				errs = append(errs, fmt.Errorf("%w: %s", ErrClearingConfigItem, backup.key))
+			}
+			continue
+		}
+		for _, value := range backup.values {
+			if !lxc.setConfigItem(backup.key, value) {
+				errs = append(errs, fmt.Errorf("%w: %s = %q", ErrSettingConfigItem, backup.key, value))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// saves the configuration next to path and renames it into place so that
+// readers never see a partially written file
+func (lxc *Container) saveConfigAtomic(path string) error {
+	dir, base := filepath.Split(path)
+
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if !lxc.saveConfigFile(tmp.Name()) {
+		return fmt.Errorf("%w: %s", ErrSavingConfigFile, path)
+	}
+	if info, err := os.Stat(path); err == nil {
+		if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+	if err := syncFile(tmp.Name()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
@@ -15,7 +15,7 @@ package lxc
 import "C"
 
 import (
-	"strconv"
+	"fmt"
 	"strings"
 	"sync"
 	"time"
@@ -31,6 +31,10 @@ type Container struct {
 func (lxc *Container) Name() string {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
+	return lxc.name()
+}
+
+func (lxc *Container) name() string {
 	return C.GoString(lxc.container.name)
 }
 
@@ -45,6 +49,10 @@ func (lxc *Container) Defined() bool {
 func (lxc *Container) Running() bool {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
+	return lxc.running()
+}
+
+func (lxc *Container) running() bool {
 	return bool(C.lxc_container_running(lxc.container))
 }
 
@@ -59,6 +67,10 @@ func (lxc *Container) State() State {
 func (lxc *Container) InitPID() int {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
+	return lxc.initPID()
+}
+
+func (lxc *Container) initPID() int {
 	return int(C.lxc_container_init_pid(lxc.container))
 }
 
@@ -90,7 +102,9 @@ func (lxc *Container) Unfreeze() bool {
 	return bool(C.lxc_container_unfreeze(lxc.container))
 }
 
-// Creates the container using given template and arguments
+// Creates the container using given template and arguments. The template
+// always runs, containers are created from cached images with
+// CreateFromImage.
 func (lxc *Container) Create(template string, args []string) bool {
 	lxc.mu.Lock()
 	defer lxc.mu.Unlock()
@@ -156,7 +170,10 @@ func (lxc *Container) Wait(state State, timeout int) bool {
 func (lxc *Container) ConfigFileName() string {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
+	return lxc.configFileName()
+}
 
+func (lxc *Container) configFileName() string {
 	// allocated in lxc.c
 	configFileName := C.lxc_container_config_file_name(lxc.container)
 	defer C.free(unsafe.Pointer(configFileName))
@@ -169,7 +186,7 @@ func (lxc *Container) ConfigItem(key string) []string {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
 
-	ckey := C.CString(key)
+	ckey := C.CString(translateConfigKey(key))
 	defer C.free(unsafe.Pointer(ckey))
 
 	// allocated in lxc.c
@@ -180,11 +197,20 @@ func (lxc *Container) ConfigItem(key string) []string {
 	return strings.Split(ret, "\n")
 }
 
-// Sets the value of given key
+// Sets the value of given key, obviously invalid values of known keys are
+// rejected before reaching liblxc
 func (lxc *Container) SetConfigItem(key string, value string) bool {
+	if validateConfigItem(key, value) != nil {
+		return false
+	}
+
 	lxc.mu.Lock()
 	defer lxc.mu.Unlock()
-	ckey := C.CString(key)
+	return lxc.setConfigItem(key, value)
+}
+
+func (lxc *Container) setConfigItem(key string, value string) bool {
+	ckey := C.CString(translateConfigKey(key))
 	defer C.free(unsafe.Pointer(ckey))
 	cvalue := C.CString(value)
 	defer C.free(unsafe.Pointer(cvalue))
@@ -195,7 +221,10 @@ func (lxc *Container) SetConfigItem(key string, value string) bool {
 func (lxc *Container) CgroupItem(key string) []string {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
+	return lxc.cgroupItem(key)
+}
 
+func (lxc *Container) cgroupItem(key string) []string {
 	ckey := C.CString(key)
 	defer C.free(unsafe.Pointer(ckey))
 
@@ -211,6 +240,10 @@ func (lxc *Container) CgroupItem(key string) []string {
 func (lxc *Container) SetCgroupItem(key string, value string) bool {
 	lxc.mu.Lock()
 	defer lxc.mu.Unlock()
+	return lxc.setCgroupItem(key, value)
+}
+
+func (lxc *Container) setCgroupItem(key string, value string) bool {
 	ckey := C.CString(key)
 	defer C.free(unsafe.Pointer(ckey))
 	cvalue := C.CString(value)
@@ -222,7 +255,11 @@ func (lxc *Container) SetCgroupItem(key string, value string) bool {
 func (lxc *Container) ClearConfigItem(key string) bool {
 	lxc.mu.Lock()
 	defer lxc.mu.Unlock()
-	ckey := C.CString(key)
+	return lxc.clearConfigItem(key)
+}
+
+func (lxc *Container) clearConfigItem(key string) bool {
+	ckey := C.CString(translateConfigKey(key))
 	defer C.free(unsafe.Pointer(ckey))
 	return bool(C.lxc_container_clear_config_item(lxc.container, ckey))
 }
@@ -232,7 +269,7 @@ func (lxc *Container) Keys(key string) []string {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
 
-	ckey := C.CString(key)
+	ckey := C.CString(translateConfigKey(key))
 	defer C.free(unsafe.Pointer(ckey))
 
 	// allocated in lxc.c
@@ -256,6 +293,10 @@ func (lxc *Container) LoadConfigFile(path string) bool {
 func (lxc *Container) SaveConfigFile(path string) bool {
 	lxc.mu.Lock()
 	defer lxc.mu.Unlock()
+	return lxc.saveConfigFile(path)
+}
+
+func (lxc *Container) saveConfigFile(path string) bool {
 	cpath := C.CString(path)
 	defer C.free(unsafe.Pointer(cpath))
 	return bool(C.lxc_container_save_config(lxc.container, cpath))
@@ -277,63 +318,60 @@ func (lxc *Container) SetConfigPath(path string) bool {
 	return bool(C.lxc_container_set_config_path(lxc.container, cpath))
 }
 
+// Returns the number of network interfaces of the running container, -1 if
+// it isn't running
 func (lxc *Container) NumberOfNetworkInterfaces() int {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		return len(lxc.ConfigItem("lxc.network"))
+	if !lxc.running() {
+		return -1
+	}
+
+	n := 0
+	for {
+		if _, ok, err := lxc.configValue(fmt.Sprintf("lxc.net.%d.type", n)); err != nil || !ok {
+			return n
+		}
+		n++
 	}
-	return -1
 }
 
+// Returns the memory usage of the container
 func (lxc *Container) MemoryUsageInBytes() (ByteSize, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		memUsed, err := strconv.ParseFloat(lxc.CgroupItem("memory.usage_in_bytes")[0], 64)
-		if err != nil {
-			return -1, err
-		}
-		return ByteSize(memUsed), err
+	if lxc.running() {
+		return lxc.memoryUsage()
 	}
 	return -1, nil
 }
 
+// Returns the memory plus swap usage of the container
 func (lxc *Container) SwapUsageInBytes() (ByteSize, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		swapUsed, err := strconv.ParseFloat(lxc.CgroupItem("memory.memsw.usage_in_bytes")[0], 64)
-		if err != nil {
-			return -1, err
-		}
-		return ByteSize(swapUsed), err
+	if lxc.running() {
+		return lxc.swapUsage()
 	}
 	return -1, nil
 }
 
+// Returns the memory limit of the container
 func (lxc *Container) MemoryLimitInBytes() (ByteSize, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		memLimit, err := strconv.ParseFloat(lxc.CgroupItem("memory.limit_in_bytes")[0], 64)
-		if err != nil {
-			return -1, err
-		}
-		return ByteSize(memLimit), err
+	if lxc.running() {
+		return lxc.memoryLimit()
 	}
 	return -1, nil
 }
 
+// Returns the memory plus swap limit of the container
 func (lxc *Container) SwapLimitInBytes() (ByteSize, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		swapLimit, err := strconv.ParseFloat(lxc.CgroupItem("memory.memsw.limit_in_bytes")[0], 64)
-		if err != nil {
-			return -1, err
-		}
-		return ByteSize(swapLimit), err
+	if lxc.running() {
+		return lxc.swapLimit()
 	}
 	return -1, nil
 }
@@ -342,31 +380,19 @@ func (lxc *Container) SwapLimitInBytes() (ByteSize, error) {
 func (lxc *Container) CPUTime() (time.Duration, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		cpuUsage, err := strconv.ParseInt(lxc.CgroupItem("cpuacct.usage")[0], 10, 64)
-		if err != nil {
-			return -1, err
-		}
-		return time.Duration(cpuUsage), err
+	if lxc.running() {
+		return lxc.cpuTime()
 	}
 	return -1, nil
 }
 
 // Returns the CPU time (in nanoseconds) consumed on each CPU by all tasks in this cgroup (including tasks lower in the hierarchy).
+// cgroup v2 doesn't account CPU time per CPU, ErrNotSupported is returned on such hosts.
 func (lxc *Container) CPUTimePerCPU() ([]time.Duration, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	var cpuTimes []time.Duration
-
-	if lxc.Running() {
-		for _, v := range strings.Split(lxc.CgroupItem("cpuacct.usage_percpu")[0], " ") {
-			cpuUsage, err := strconv.ParseInt(v, 10, 64)
-			if err != nil {
-				return nil, err
-			}
-			cpuTimes = append(cpuTimes, time.Duration(cpuUsage))
-		}
-		return cpuTimes, nil
+	if lxc.running() {
+		return lxc.cpuTimePerCPU()
 	}
 	return nil, nil
 }
@@ -375,11 +401,8 @@ func (lxc *Container) CPUTimePerCPU() ([]time.Duration, error) {
 func (lxc *Container) CPUStats() ([]int64, error) {
 	lxc.mu.RLock()
 	defer lxc.mu.RUnlock()
-	if lxc.Running() {
-		cpuStat := lxc.CgroupItem("cpuacct.stat")
-		user, _ := strconv.ParseInt(strings.Split(cpuStat[0], " ")[1], 10, 64)
-		system, _ := strconv.ParseInt(strings.Split(cpuStat[1], " ")[1], 10, 64)
-		return []int64{user, system}, nil
+	if lxc.running() {
+		return lxc.cpuStats()
 	}
 	return nil, nil
 }
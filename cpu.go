@@ -0,0 +1,217 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"errors"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CPU utilisation of a container between two samples, in percent of a single
+// CPU (200 meaning two CPUs fully used)
+type CPUUsage struct {
+	Time     time.Time
+	Interval time.Duration
+
+	Percent float64
+	// Percent relative to the CPUs available to the container, 0-100
+	NormalizedPercent float64
+	// nil on cgroup v2 hosts
+	PerCPU []float64
+	User   float64
+	System float64
+
+	// CPUs available to the container according to its cpuset and CFS quota
+	CPUs float64
+
+	// CFS periods elapsed and throttled, and the time spent throttled,
+	// between the two samples
+	Periods          uint64
+	ThrottledPeriods uint64
+	ThrottledTime    time.Duration
+}
+
+// Turns the cumulative CPU counters of a container into utilisation
+type CPUSampler struct {
+	container *Container
+	last      *cpuSample
+	mu        sync.Mutex
+}
+
+type cpuSample struct {
+	time   time.Time
+	total  time.Duration
+	perCPU []time.Duration
+	// in USER_HZ
+	user   int64
+	system int64
+
+	periods          uint64
+	throttledPeriods uint64
+	throttledTime    time.Duration
+
+	cpus float64
+}
+
+// Returns a sampler for the container, taking the first reading
+func NewCPUSampler(c *Container) (*CPUSampler, error) {
+	sample, err := c.cpuSample()
+	if err != nil {
+		return nil, err
+	}
+	return &CPUSampler{container: c, last: sample}, nil
+}
+
+// Takes a reading and returns the utilisation since the previous one
+func (s *CPUSampler) Sample() (*CPUUsage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample, err := s.container.cpuSample()
+	if err != nil {
+		return nil, err
+	}
+	usage := cpuUsage(s.last, sample)
+	s.last = sample
+	return usage, nil
+}
+
+func cpuUsage(prev *cpuSample, cur *cpuSample) *CPUUsage {
+	interval := cur.time.Sub(prev.time)
+	usage := &CPUUsage{
+		Time:     cur.time,
+		Interval: interval,
+		CPUs:     cur.cpus,
+
+		Periods:          cur.periods - prev.periods,
+		ThrottledPeriods: cur.throttledPeriods - prev.throttledPeriods,
+		ThrottledTime:    cur.throttledTime - prev.throttledTime,
+	}
+	if interval <= 0 {
+		return usage
+	}
+
+	percent := func(d time.Duration) float64 {
+		return 100 * float64(d) / float64(interval)
+	}
+	ticks := func(n int64) time.Duration {
+		return time.Duration(n) * time.Second / userHZ
+	}
+
+	usage.Percent = percent(cur.total - prev.total)
+	if cur.cpus > 0 {
+		usage.NormalizedPercent = usage.Percent / cur.cpus
+	}
+	usage.User = percent(ticks(cur.user - prev.user))
+	usage.System = percent(ticks(cur.system - prev.system))
+
+	// CPUs going online change the length
+	if cur.perCPU != nil && len(cur.perCPU) == len(prev.perCPU) {
+		usage.PerCPU = make([]float64, len(cur.perCPU))
+		for i := range cur.perCPU {
+			usage.PerCPU[i] = percent(cur.perCPU[i] - prev.perCPU[i])
+		}
+	}
+	return usage
+}
+
+func (lxc *Container) cpuSample() (*cpuSample, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	sample := &cpuSample{time: time.Now()}
+
+	var err error
+	if sample.total, err = lxc.cpuTime(); err != nil {
+		return nil, err
+	}
+	sample.perCPU, err = lxc.cpuTimePerCPU()
+	if err != nil && !errors.Is(err, ErrNotSupported) {
+		return nil, err
+	}
+	stats, err := lxc.cpuStats()
+	if err != nil {
+		return nil, err
+	}
+	sample.user, sample.system = stats[0], stats[1]
+
+	// missing when the cpu controller is not enabled
+	if throttling, err := lxc.cgroupStats("cpu.stat"); err == nil {
+		sample.periods = throttling["nr_periods"]
+		sample.throttledPeriods = throttling["nr_throttled"]
+		if unifiedCgroup() {
+			sample.throttledTime = time.Duration(throttling["throttled_usec"]) * time.Microsecond
+		} else {
+			sample.throttledTime = time.Duration(throttling["throttled_time"])
+		}
+	}
+
+	sample.cpus = lxc.availableCPUs()
+	return sample, nil
+}
+
+// Returns the number of CPUs the container may use, the smaller of its cpuset
+// size and its CFS quota
+func (lxc *Container) availableCPUs() float64 {
+	cpus := float64(runtime.NumCPU())
+
+	key := "cpuset.cpus"
+	if unifiedCgroup() {
+		key = "cpuset.cpus.effective"
+	}
+	if n := cpuListSize(lxc.cgroupItem(key)[0]); n > 0 {
+		cpus = float64(n)
+	}
+
+	var quota, period float64
+	if unifiedCgroup() {
+		// "max 100000" without a quota
+		fields := strings.Fields(lxc.cgroupItem("cpu.max")[0])
+		if len(fields) == 2 {
+			quota, _ = strconv.ParseFloat(fields[0], 64)
+			period, _ = strconv.ParseFloat(fields[1], 64)
+		}
+	} else {
+		quota, _ = strconv.ParseFloat(lxc.cgroupItem("cpu.cfs_quota_us")[0], 64)
+		period, _ = strconv.ParseFloat(lxc.cgroupItem("cpu.cfs_period_us")[0], 64)
+	}
+	if quota > 0 && period > 0 {
+		cpus = math.Min(cpus, quota/period)
+	}
+	return cpus
+}
+
+// Returns the number of CPUs in a list like "0-3,6", or 0 if it is invalid
+func cpuListSize(list string) int {
+	if !validCPUList(list) {
+		return 0
+	}
+
+	size := 0
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			size++
+			continue
+		}
+		start, _ := strconv.Atoi(first)
+		end, _ := strconv.Atoi(last)
+		size += end - start + 1
+	}
+	return size
+}
@@ -0,0 +1,50 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotDefined         = errors.New("container is not defined")
+	ErrNotRunning         = errors.New("container is not running")
+	ErrInvalidConfigKey   = errors.New("invalid config key")
+	ErrInvalidConfigValue = errors.New("invalid config value")
+	ErrInvalidByteSize    = errors.New("invalid byte size")
+	ErrSettingConfigItem  = errors.New("setting config item failed")
+	ErrClearingConfigItem = errors.New("clearing config item failed")
+	ErrSavingConfigFile   = errors.New("saving config file failed")
+	ErrReadingCgroupItem  = errors.New("reading cgroup item failed")
+	ErrSettingCgroupItem  = errors.New("setting cgroup item failed")
+	ErrNotSupported       = errors.New("not supported on this host")
+	ErrInvalidDevice      = errors.New("invalid device")
+	ErrCgroupNotFound     = errors.New("cgroup not found")
+	ErrRunning            = errors.New("container is running")
+	ErrInvalidArchive     = errors.New("invalid archive")
+	ErrChecksumMismatch   = errors.New("checksum mismatch")
+	ErrAlreadyDefined     = errors.New("container is already defined")
+	ErrImageNotFound      = errors.New("image not found")
+	ErrAmbiguousImage     = errors.New("ambiguous image id")
+	ErrHostConfig         = errors.New("config item gives access to the host")
+	ErrInvalidInterval    = errors.New("invalid interval")
+)
+
+// Returned by the resource limit setters for values the kernel would reject
+type InvalidLimitError struct {
+	Limit  string
+	Value  string
+	Reason string
+}
+
+func (e *InvalidLimitError) Error() string {
+	return fmt.Sprintf("invalid %s limit %q: %s", e.Limit, e.Value, e.Reason)
+}
@@ -0,0 +1,63 @@
+/*
+ * audit.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"os"
+)
+
+var (
+	name   string
+	asJSON bool
+)
+
+func init() {
+	flag.StringVar(&name, "name", "rubik", "Name of the container")
+	flag.BoolVar(&asJSON, "json", false, "Print the report as JSON")
+	flag.Parse()
+}
+
+func main() {
+	c := lxc.NewContainer(name)
+	defer lxc.PutContainer(c)
+
+	report, err := lxc.Audit(c)
+	if err != nil {
+		fmt.Printf("Auditing the container failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	if asJSON {
+		data, err := report.JSON()
+		if err != nil {
+			fmt.Printf("Encoding the report failed: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	fmt.Print(report)
+}
@@ -0,0 +1,67 @@
+/*
+ * concurrent_create_image.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+}
+
+func main() {
+	store, err := lxc.NewImageStore("")
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+	// added with the images example, the rootfs is extracted for each
+	// container instead of being rebuilt
+	image, err := store.Find("ubuntu", "quantal", "amd64")
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			z := lxc.NewContainer(strconv.Itoa(i))
+			defer lxc.PutContainer(z)
+
+			fmt.Printf("Creating the container (%d)...\n", i)
+			if err := z.CreateFromImage(store, image.ID, lxc.TemplateOptions{}); err != nil {
+				fmt.Printf("Creating the container (%d) failed: %s\n", i, err.Error())
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,69 @@
+/*
+ * create_template.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"strings"
+)
+
+var (
+	name    string
+	tarball string
+	dir     string
+	idmap   string
+)
+
+func init() {
+	flag.StringVar(&name, "name", "rubik", "Name of the container")
+	flag.StringVar(&tarball, "tarball", "", "Rootfs tarball to create the container from")
+	flag.StringVar(&dir, "dir", "", "Directory to copy the rootfs from")
+	flag.StringVar(&idmap, "idmap", "", "Comma separated lxc.idmap values, like \"u 0 100000 65536,g 0 100000 65536\"")
+	flag.Parse()
+}
+
+func main() {
+	c := lxc.NewContainer(name)
+	defer lxc.PutContainer(c)
+
+	// a busybox system unless a tarball or a directory is given
+	var t lxc.Template = &lxc.BusyboxTemplate{}
+	switch {
+	case tarball != "":
+		t = &lxc.TarballTemplate{Path: tarball}
+	case dir != "":
+		t = &lxc.DirectoryTemplate{Path: dir}
+	}
+
+	var opts lxc.TemplateOptions
+	if idmap != "" {
+		opts.IDMap = strings.Split(idmap, ",")
+	}
+
+	fmt.Printf("Creating container...\n")
+	if err := c.CreateFromTemplate(t, opts); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+	}
+}
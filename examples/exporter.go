@@ -0,0 +1,116 @@
+/*
+ * exporter.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"github.com/caglar10ur/lxc/exporter"
+	"net/http"
+	"strings"
+)
+
+var (
+	listen   string
+	lxcpaths string
+)
+
+func init() {
+	flag.StringVar(&listen, "listen", ":9125", "Address to serve the metrics on")
+	flag.StringVar(&lxcpaths, "lxcpath", lxc.DefaultConfigPath(), "Comma separated list of lxcpaths")
+	flag.Parse()
+}
+
+type provider struct {
+	lxcpaths []string
+}
+
+func (p *provider) Containers() ([]exporter.ContainerStats, error) {
+	var containers []exporter.ContainerStats
+
+	for _, lxcpath := range p.lxcpaths {
+		for _, name := range lxc.ContainerNames(lxcpath) {
+			c := lxc.NewContainer(name, lxcpath)
+			containers = append(containers, containerStats(c, name, lxcpath))
+			lxc.PutContainer(c)
+		}
+	}
+	return containers, nil
+}
+
+func containerStats(c *lxc.Container, name string, lxcpath string) exporter.ContainerStats {
+	s := exporter.ContainerStats{Name: name, LXCPath: lxcpath, State: c.State().String()}
+
+	stats, err := c.Stats()
+	if err != nil {
+		// stopped in the meantime
+		return s
+	}
+	s.Running = true
+	s.InitPID = c.InitPID()
+	s.MemoryUsage = int64(stats.MemoryUsage)
+	s.MemoryLimit = int64(stats.MemoryLimit)
+	s.SwapUsage = int64(stats.SwapUsage)
+	s.SwapLimit = int64(stats.SwapLimit)
+	s.CPUTime = stats.CPUTime
+	s.PidsCurrent = stats.PidsCurrent
+	s.PidsLimit = stats.PidsLimit
+
+	for _, b := range stats.BlockIO {
+		device := b.Device
+		if device == "" {
+			device = fmt.Sprintf("%d:%d", b.Major, b.Minor)
+		}
+		s.BlockIO = append(s.BlockIO, exporter.BlockIO{
+			Device:     device,
+			ReadBytes:  b.ReadBytes,
+			WriteBytes: b.WriteBytes,
+			ReadOps:    b.ReadOps,
+			WriteOps:   b.WriteOps,
+		})
+	}
+	for _, n := range stats.Network {
+		s.Network = append(s.Network, exporter.Network{
+			Interface: n.Interface,
+			RxBytes:   n.Stats.RxBytes,
+			RxPackets: n.Stats.RxPackets,
+			RxErrors:  n.Stats.RxErrors,
+			RxDropped: n.Stats.RxDropped,
+			TxBytes:   n.Stats.TxBytes,
+			TxPackets: n.Stats.TxPackets,
+			TxErrors:  n.Stats.TxErrors,
+			TxDropped: n.Stats.TxDropped,
+		})
+	}
+	return s
+}
+
+func main() {
+	http.Handle("/metrics", exporter.NewHandler(&provider{lxcpaths: strings.Split(lxcpaths, ",")}))
+
+	fmt.Printf("Serving metrics on %s/metrics\n", listen)
+	if err := http.ListenAndServe(listen, nil); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * images.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"os"
+	"time"
+)
+
+var (
+	lxcpath string
+	add     string
+	distro  string
+	release string
+	arch    string
+	remove  string
+	prune   time.Duration
+)
+
+func init() {
+	flag.StringVar(&lxcpath, "lxcpath", "", "Directory of the containers")
+	flag.StringVar(&add, "add", "", "Rootfs tarball to add to the store")
+	flag.StringVar(&distro, "distro", "ubuntu", "Distribution of the added tarball")
+	flag.StringVar(&release, "release", "quantal", "Release of the added tarball")
+	flag.StringVar(&arch, "arch", "amd64", "Architecture of the added tarball")
+	flag.StringVar(&remove, "remove", "", "ID of the image to remove")
+	flag.DurationVar(&prune, "prune", 0, "Remove the images unused for this long")
+	flag.Parse()
+}
+
+func main() {
+	store, err := lxc.NewImageStore(lxcpath)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+
+	switch {
+	case add != "":
+		f, err := os.Open(add)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			return
+		}
+		defer f.Close()
+
+		image, err := store.Add(f, lxc.ImageMetadata{Distro: distro, Release: release, Arch: arch})
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			return
+		}
+		fmt.Printf("Added %s\n", image.ID)
+	case remove != "":
+		if err := store.Remove(remove); err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+		}
+	case prune != 0:
+		removed, err := store.Prune(prune)
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+		}
+		for _, image := range removed {
+			fmt.Printf("Removed %s\n", image.ID)
+		}
+	default:
+		images, err := store.List()
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			return
+		}
+		for _, image := range images {
+			fmt.Printf("%.12s %s %s %s %s %d\n", image.ID, image.Distro, image.Release, image.Arch, image.Created.Format(time.RFC3339), image.Size)
+		}
+	}
+}
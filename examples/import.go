@@ -0,0 +1,62 @@
+/*
+ * import.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"os"
+)
+
+var (
+	name    string
+	input   string
+	lxcpath string
+	trusted bool
+)
+
+func init() {
+	flag.StringVar(&name, "name", "rubik", "Name of the container")
+	flag.StringVar(&input, "input", "rubik.tar.gz", "Archive written by export")
+	flag.StringVar(&lxcpath, "lxcpath", "", "Directory of the containers")
+	flag.BoolVar(&trusted, "trusted", false, "Keep hooks and host mounts of the archive")
+	flag.Parse()
+}
+
+func main() {
+	f, err := os.Open(input)
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+	defer f.Close()
+
+	fmt.Printf("Importing the container from %s...\n", input)
+	c, err := lxc.Import(f, name, lxcpath, lxc.ImportOptions{AllowHostConfig: trusted})
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+	defer lxc.PutContainer(c)
+}
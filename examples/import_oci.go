@@ -0,0 +1,53 @@
+/*
+ * import_oci.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"github.com/caglar10ur/lxc/oci"
+)
+
+var (
+	name   string
+	layout string
+	ref    string
+)
+
+func init() {
+	flag.StringVar(&name, "name", "rubik", "Name of the container")
+	flag.StringVar(&layout, "layout", "", "Directory of the OCI image layout")
+	flag.StringVar(&ref, "ref", "", "Image of the layout to import")
+	flag.Parse()
+}
+
+func main() {
+	fmt.Printf("Importing %s from %s...\n", ref, layout)
+	c, err := oci.Import(layout, name, oci.Options{Ref: ref})
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+	defer lxc.PutContainer(c)
+}
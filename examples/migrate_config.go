@@ -0,0 +1,55 @@
+/*
+ * migrate_config.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"os"
+)
+
+var (
+	config string
+	dryRun bool
+)
+
+func init() {
+	flag.StringVar(&config, "config", "/var/lib/lxc/rubik/config", "Path of the configuration file")
+	flag.BoolVar(&dryRun, "dry-run", false, "Only report the changes")
+	flag.Parse()
+}
+
+func main() {
+	migration, err := lxc.MigrateConfig(config, dryRun)
+	if err != nil {
+		fmt.Printf("Migrating the config failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(migration.Changes) == 0 {
+		fmt.Printf("Config is already up to date...\n")
+		return
+	}
+	fmt.Print(migration.Diff())
+}
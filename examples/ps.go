@@ -0,0 +1,63 @@
+/*
+ * ps.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"strings"
+)
+
+var (
+	name string
+)
+
+func init() {
+	flag.StringVar(&name, "name", "rubik", "Name of the container")
+	flag.Parse()
+}
+
+func main() {
+	c := lxc.NewContainer(name)
+	defer lxc.PutContainer(c)
+
+	processes, err := c.Processes()
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
+
+	fmt.Printf("%-10s %7s %7s %7s %-5s %10s %10s  %s\n", "USER", "PID", "NSPID", "PPID", "STAT", "RSS", "TIME", "COMMAND")
+	for _, p := range processes {
+		user := p.User
+		if user == "" {
+			user = fmt.Sprintf("%d", p.UID)
+		}
+		command := strings.Join(p.CmdLine, " ")
+		if command == "" {
+			command = "[" + p.Command + "]"
+		}
+		fmt.Printf("%-10s %7d %7d %7d %-5s %10s %10s  %s\n", user, p.PID, p.NSPID, p.PPID, p.State, p.RSS, p.CPUTime, command)
+	}
+}
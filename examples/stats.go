@@ -42,19 +42,48 @@ func main() {
 	defer lxc.PutContainer(c)
 
 	if c.Running() {
-		// mem
-		mem_used, _ := c.MemoryUsageInBytes()
-		fmt.Printf("mem_used: %s\n", mem_used)
+		stats, err := c.Stats()
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			return
+		}
 
-		mem_limit, _ := c.MemoryLimitInBytes()
-		fmt.Printf("mem_limit: %s\n", mem_limit)
+		// mem
+		fmt.Printf("mem_used: %s\n", stats.MemoryUsage)
+		fmt.Printf("mem_limit: %s\n", stats.MemoryLimit)
 
 		// swap
-		swap_used, _ := c.SwapUsageInBytes()
-		fmt.Printf("memsw_used: %s\n", swap_used)
+		fmt.Printf("memsw_used: %s\n", stats.SwapUsage)
+		fmt.Printf("memsw_limit: %s\n", stats.SwapLimit)
+
+		// memory breakdown
+		fmt.Printf("cache: %s\n", stats.Memory.Cache)
+		fmt.Printf("rss: %s\n", stats.Memory.RSS)
+		fmt.Printf("mapped_file: %s\n", stats.Memory.MappedFile)
+		fmt.Printf("oom_kills: %d\n", stats.Memory.OOMKills)
+
+		// cpu
+		fmt.Printf("cpu_time: %s\n", stats.CPUTime)
+		fmt.Printf("cpu_user: %s\n", stats.CPUUser)
+		fmt.Printf("cpu_system: %s\n", stats.CPUSystem)
+
+		// pids
+		fmt.Printf("pids: %d\n", stats.PidsCurrent)
+
+		// block io
+		for _, s := range stats.BlockIO {
+			fmt.Printf("%d:%d: read_bytes: %d write_bytes: %d\n", s.Major, s.Minor, s.ReadBytes, s.WriteBytes)
+		}
 
-		swap_limit, _ := c.SwapLimitInBytes()
-		fmt.Printf("memsw_used: %s\n", swap_limit)
+		// network
+		for _, s := range stats.Network {
+			fmt.Printf("%s: rx_bytes: %d tx_bytes: %d rx_dropped: %d tx_dropped: %d\n",
+				s.Interface, s.Stats.RxBytes, s.Stats.TxBytes, s.Stats.RxDropped, s.Stats.TxDropped)
+			if s.HostInterface != "" {
+				fmt.Printf("%s (host): rx_bytes: %d tx_bytes: %d\n",
+					s.HostInterface, s.HostStats.RxBytes, s.HostStats.TxBytes)
+			}
+		}
 	} else {
 		fmt.Printf("Container is not running...\n")
 	}
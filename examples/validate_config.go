@@ -0,0 +1,58 @@
+/*
+ * validate_config.go
+ *
+ * Copyright © 2013, S.Çağlar Onur
+ *
+ * Authors:
+ * S.Çağlar Onur <caglar@10ur.org>
+ *
+ * This library is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 2, as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"os"
+)
+
+var (
+	config string
+)
+
+func init() {
+	flag.StringVar(&config, "config", "/var/lib/lxc/rubik/config", "Path of the configuration file")
+	flag.Parse()
+}
+
+func main() {
+	diagnostics, err := lxc.ValidateConfig(config)
+	if err != nil {
+		fmt.Printf("Validating the config failed: %s\n", err)
+		os.Exit(1)
+	}
+
+	errors := 0
+	for _, d := range diagnostics {
+		fmt.Println(d)
+		if d.Severity == lxc.CONFIG_ERROR {
+			errors++
+		}
+	}
+	if errors > 0 {
+		os.Exit(1)
+	}
+}
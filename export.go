@@ -0,0 +1,486 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Options of Export
+type ExportOptions struct {
+	// gzip compression level, gzip.DefaultCompression if zero
+	Level int
+	// container paths left out of the archive, like /var/cache/apt/archives
+	Exclude []string
+}
+
+// Options of Import
+type ImportOptions struct {
+	// Keeps config items of the archive that run commands on the host or
+	// expose it to the container, like hooks, network scripts, includes, log
+	// files, mounts of host paths or devices, writable proc, sys and cgroup
+	// mounts, access to all devices and the unconfined AppArmor profile.
+	// Archives holding any of them are refused with ErrHostConfig unless this
+	// is set, only set it for archives of trusted origin.
+	AllowHostConfig bool
+}
+
+// Metadata of an exported container, the last entry of the archive
+type ExportManifest struct {
+	Name       string    `json:"name"`
+	LXCVersion string    `json:"lxc_version"`
+	Arch       string    `json:"arch"`
+	Created    time.Time `json:"created"`
+	// directory of the container on the exporting host
+	Path string `json:"path"`
+	// sha256 of the config and of the regular files of the rootfs, keyed by
+	// their names in the archive
+	Checksums map[string]string `json:"checksums"`
+}
+
+// names of the entries of an exported container
+const (
+	exportConfig   = "config"
+	exportRootFS   = "rootfs"
+	exportManifest = "manifest.json"
+)
+
+// directory the rootfs is extracted to until the archive is verified
+const importStaging = ".rootfs-staging"
+
+// Writes the stopped container as a gzip compressed tarball holding its
+// config, its rootfs and a manifest. Overlay rootfs are flattened and owners
+// are stored as IDs of the container, so the archive can be imported on hosts
+// with different ID mappings.
+func Export(c *Container, w io.Writer, opts ExportOptions) error {
+	if !c.Defined() {
+		return ErrNotDefined
+	}
+
+	c.mu.RLock()
+	if c.running() {
+		c.mu.RUnlock()
+		return fmt.Errorf("%w: stop it before exporting", ErrRunning)
+	}
+	configFile := c.configFileName()
+	arch, _, _ := c.configValue("lxc.arch")
+	root, err := c.containerRoot()
+	ids := c.idMaps()
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	config, err := os.ReadFile(configFile)
+	if err != nil {
+		return err
+	}
+	if arch == "" {
+		arch = runtime.GOARCH
+	}
+	dir := filepath.Dir(configFile)
+	manifest := &ExportManifest{
+		Name:       filepath.Base(dir),
+		LXCVersion: Version(),
+		Arch:       arch,
+		Created:    time.Now().UTC(),
+		Path:       dir,
+	}
+	return writeExport(w, opts, &containerFiles{root: root, ids: ids}, config, manifest)
+}
+
+func writeExport(w io.Writer, opts ExportOptions, files *containerFiles, config []byte, manifest *ExportManifest) error {
+	level := opts.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gz)
+
+	manifest.Checksums = make(map[string]string)
+	if err := writeTarFile(tw, exportConfig, config, 0640); err != nil {
+		return err
+	}
+	manifest.Checksums[exportConfig] = sha256Hex(config)
+
+	exclude := make(map[string]bool)
+	for _, name := range opts.Exclude {
+		exclude[containerName(name)] = true
+	}
+	err = files.addTree(tw, ".", treeOptions{prefix: exportRootFS, exclude: exclude, sums: manifest.Checksums})
+	if err != nil {
+		return err
+	}
+
+	// written last, once the checksums are known
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeTarFile(tw, exportManifest, data, 0644); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, mode int64) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     mode,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Recreates a container written by Export as name in lxcpath, or in the
+// default one if lxcpath is empty. Paths of the exporting host in the config
+// are rewritten to the new container directory. If the archive doesn't match
+// the checksums of its manifest, or holds config items giving access to the
+// host that opts doesn't allow, nothing is left behind.
+func Import(r io.Reader, name string, lxcpath string, opts ImportOptions) (*Container, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("%w: container name %q", ErrInvalidArchive, name)
+	}
+	if lxcpath == "" {
+		lxcpath = DefaultConfigPath()
+	}
+
+	dir := filepath.Join(lxcpath, name)
+	// fails if the container exists
+	if err := os.Mkdir(dir, 0750); err != nil {
+		return nil, err
+	}
+	if _, err := readExport(r, dir, opts); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return NewContainer(name, lxcpath), nil
+}
+
+// Extracts an exported container into dir and verifies it. The rootfs is
+// extracted to a staging directory, renamed once the archive is verified.
+func readExport(r io.Reader, dir string, opts ImportOptions) (*ExportManifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	staging := filepath.Join(dir, importStaging)
+	if err := os.Mkdir(staging, 0755); err != nil {
+		return nil, err
+	}
+	manifest, config, err := extractExport(gz, staging)
+	if err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+
+	config = rewriteConfig(config, manifest.Path, dir, manifest.Name, filepath.Base(dir))
+	if !opts.AllowHostConfig {
+		if err := checkHostConfig(config, dir, staging); err != nil {
+			os.RemoveAll(staging)
+			return nil, err
+		}
+	}
+	if err := os.Rename(staging, filepath.Join(dir, exportRootFS)); err != nil {
+		os.RemoveAll(staging)
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, exportConfig), []byte(config), 0640); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// Extracts the rootfs of an exported container into rootfs and returns its
+// verified manifest and config
+func extractExport(r io.Reader, rootfs string) (*ExportManifest, string, error) {
+	var (
+		config   []byte
+		files    *containerFiles
+		manifest *ExportManifest
+		sums     = make(map[string]string)
+	)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+		if manifest != nil {
+			return nil, "", fmt.Errorf("%w: %s follows the manifest", ErrInvalidArchive, hdr.Name)
+		}
+
+		switch {
+		case hdr.Name == exportConfig:
+			if config, err = io.ReadAll(tr); err != nil {
+				return nil, "", err
+			}
+			sums[exportConfig] = sha256Hex(config)
+		case hdr.Name == exportManifest:
+			manifest = &ExportManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, "", fmt.Errorf("%w: %s: %s", ErrInvalidArchive, exportManifest, err)
+			}
+		case hdr.Name == exportRootFS+"/" || strings.HasPrefix(hdr.Name, exportRootFS+"/"):
+			if files == nil {
+				// owners are mapped with the imported ID mappings
+				if config == nil {
+					return nil, "", fmt.Errorf("%w: the rootfs precedes the config", ErrInvalidArchive)
+				}
+				idmap := strings.Join(configFileValues(string(config), "lxc.idmap"), "\n")
+				files = &containerFiles{root: &rootFS{layers: []string{rootfs}}, ids: parseConfigIDMap(idmap)}
+			}
+
+			var src io.Reader = tr
+			h := sha256.New()
+			if hdr.Typeflag == tar.TypeReg {
+				src = io.TeeReader(tr, h)
+			}
+			name := path.Join("/", strings.TrimPrefix(hdr.Name, exportRootFS+"/"))
+			link := path.Join("/", strings.TrimPrefix(hdr.Linkname, exportRootFS+"/"))
+			if err := files.extract(hdr, src, name, link); err != nil {
+				return nil, "", err
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				sums[strings.TrimSuffix(hdr.Name, "/")] = hex.EncodeToString(h.Sum(nil))
+			}
+		default:
+			return nil, "", fmt.Errorf("%w: unexpected entry %s", ErrInvalidArchive, hdr.Name)
+		}
+	}
+
+	if manifest == nil {
+		return nil, "", fmt.Errorf("%w: %s is missing", ErrInvalidArchive, exportManifest)
+	}
+	if err := verifyChecksums(manifest.Checksums, sums); err != nil {
+		return nil, "", err
+	}
+	return manifest, string(config), nil
+}
+
+// Compares the checksums of the manifest with the ones of the extracted files
+func verifyChecksums(expected map[string]string, actual map[string]string) error {
+	names := make([]string, 0, len(expected)+len(actual))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range actual {
+		if _, ok := expected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		want, ok := expected[name]
+		if !ok {
+			return fmt.Errorf("%w: %s isn't in the manifest", ErrChecksumMismatch, name)
+		}
+		got, ok := actual[name]
+		if !ok {
+			return fmt.Errorf("%w: %s is missing", ErrChecksumMismatch, name)
+		}
+		if got != want {
+			return fmt.Errorf("%w: %s", ErrChecksumMismatch, name)
+		}
+	}
+	return nil
+}
+
+// Returns the values of the key in a config file, legacy keys included
+func configFileValues(config string, key string) []string {
+	var values []string
+	for _, line := range strings.Split(config, "\n") {
+		k, v, ok := parseConfigLine(line)
+		if ok && modernConfigKey(k) == modernConfigKey(key) {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Points the config of an imported container to its new directory, the
+// rootfs becomes the directory the archive was extracted to
+func rewriteConfig(config string, oldDir string, newDir string, oldName string, newName string) string {
+	lines := strings.Split(config, "\n")
+	for i, line := range lines {
+		key, value, ok := parseConfigLine(line)
+		if !ok {
+			continue
+		}
+
+		switch modernConfigKey(key) {
+		case "lxc.rootfs.path":
+			value = filepath.Join(newDir, exportRootFS)
+		case "lxc.rootfs.backend":
+			value = "dir"
+		case "lxc.uts.name":
+			if value == oldName {
+				value = newName
+			}
+		default:
+			if oldDir == "" {
+				continue
+			}
+			// hooks and mount entries hold paths among other words
+			fields := strings.Fields(value)
+			changed := false
+			for j, field := range fields {
+				if field == oldDir || strings.HasPrefix(field, oldDir+"/") {
+					fields[j] = newDir + strings.TrimPrefix(field, oldDir)
+					changed = true
+				}
+			}
+			if !changed {
+				continue
+			}
+			value = strings.Join(fields, " ")
+		}
+		lines[i] = key + " = " + value
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns ErrHostConfig for the first item of the config of the container
+// imported to dir that runs commands on the host or exposes it. Bind mount
+// sources are resolved in staging, where the rootfs was extracted to.
+func checkHostConfig(config string, dir string, staging string) error {
+	for _, line := range strings.Split(config, "\n") {
+		key, value, ok := parseConfigLine(line)
+		if !ok {
+			continue
+		}
+		if hostConfigItem(modernConfigKey(key), value, dir, staging) {
+			return fmt.Errorf("%w: %s = %s", ErrHostConfig, key, value)
+		}
+	}
+	return nil
+}
+
+// config keys making liblxc write to or read from host paths or share the
+// host's namespaces, whatever their value
+var hostConfigKeys = map[string]bool{
+	"lxc.include":         true,
+	"lxc.log.file":        true,
+	"lxc.console.logfile": true,
+	"lxc.namespace.keep":  true,
+}
+
+// filesystems lxc.mount.entry may mount without a host source, proc and sysfs
+// only read-only
+var importMountTypes = map[string]bool{
+	"tmpfs":  true,
+	"devpts": true,
+	"mqueue": true,
+	"ramfs":  true,
+	"proc":   false,
+	"sysfs":  false,
+}
+
+func hostConfigItem(key string, value string, dir string, staging string) bool {
+	rootfs := filepath.Join(dir, exportRootFS)
+	// paths of the rootfs the archive shipped, resolved in staging without
+	// following symlinks, which the host's mount would follow out of it
+	inRootFS := func(name string) bool {
+		name = filepath.Clean(name)
+		if name != rootfs && !strings.HasPrefix(name, rootfs+"/") {
+			return false
+		}
+		f, err := openInRoot(staging, strings.TrimPrefix(name, rootfs), oPath)
+		if err != nil {
+			return false
+		}
+		f.Close()
+		return true
+	}
+
+	switch {
+	case hostConfigKeys[key]:
+		return true
+	case strings.HasPrefix(key, "lxc.hook.") && key != "lxc.hook.version":
+		return true
+	case strings.HasPrefix(key, "lxc.namespace.share."):
+		return true
+	case strings.HasPrefix(key, "lxc.net.") && (strings.HasSuffix(key, ".script.up") || strings.HasSuffix(key, ".script.down")):
+		return true
+	case strings.HasPrefix(key, "lxc.net.") && strings.HasSuffix(key, ".type"):
+		// the host's network or one of its interfaces
+		return value == "none" || value == "phys"
+	case key == "lxc.console.path":
+		return value != "" && value != "none" && value != "auto"
+	case key == "lxc.apparmor.profile":
+		return value == "unconfined"
+	case key == "lxc.mount.fstab":
+		// the file isn't part of the archive, unless it is in the rootfs
+		name := filepath.Clean(value)
+		return !strings.HasPrefix(name, dir+"/") || name == rootfs || strings.HasPrefix(name, rootfs+"/")
+	case key == "lxc.mount.auto":
+		for _, option := range strings.Fields(value) {
+			if option == "proc:rw" || option == "sys:rw" || strings.HasPrefix(option, "cgroup:rw") || strings.HasPrefix(option, "cgroup-full:rw") {
+				return true
+			}
+		}
+		return false
+	case key == "lxc.mount.entry":
+		fields := strings.Fields(value)
+		if len(fields) < 4 {
+			return false
+		}
+		options := strings.Split(fields[3], ",")
+		if containsString(options, "bind") || containsString(options, "rbind") {
+			return !inRootFS(fields[0])
+		}
+		allowed, ok := importMountTypes[fields[2]]
+		return !ok || !(allowed || containsString(options, "ro"))
+	case key == "lxc.cgroup.devices.allow" || key == "lxc.cgroup2.devices.allow":
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return false
+		}
+		access := len(fields) >= 3 && strings.ContainsAny(fields[2], "rw")
+		return fields[0] == "a" || (access && (fields[1] == "*:*" || fields[0] == "b"))
+	}
+	return false
+}
@@ -0,0 +1,249 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+// Prometheus exporter for LXC containers
+//
+// This package renders container statistics in the Prometheus text format. It
+// doesn't depend on liblxc, the statistics come from a Provider.
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// I/O done by a container on a single block device
+type BlockIO struct {
+	Device     string
+	ReadBytes  uint64
+	WriteBytes uint64
+	ReadOps    uint64
+	WriteOps   uint64
+}
+
+// Traffic of a network interface of a container
+type Network struct {
+	Interface string
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// Statistics of a single container, only Name, LXCPath, State and Running
+// are set for stopped containers. Negative limits mean no limit.
+type ContainerStats struct {
+	Name    string
+	LXCPath string
+	State   string
+	Running bool
+	InitPID int
+
+	MemoryUsage int64
+	MemoryLimit int64
+	SwapUsage   int64
+	SwapLimit   int64
+
+	CPUTime time.Duration
+
+	BlockIO []BlockIO
+	Network []Network
+
+	PidsCurrent uint64
+	PidsLimit   int64
+}
+
+// Source of the container statistics
+type Provider interface {
+	Containers() ([]ContainerStats, error)
+}
+
+type handler struct {
+	provider Provider
+}
+
+// Returns an http.Handler serving the statistics of the provider's containers
+func NewHandler(provider Provider) http.Handler {
+	return &handler{provider: provider}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	containers, err := h.provider.Containers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	WriteMetrics(w, containers)
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string
+	samples []sample
+}
+
+func (f *family) add(value float64, labels ...string) {
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+// Writes the statistics in the Prometheus text format
+func WriteMetrics(w io.Writer, containers []ContainerStats) error {
+	containers = append([]ContainerStats(nil), containers...)
+	sort.Slice(containers, func(i, j int) bool {
+		if containers[i].LXCPath != containers[j].LXCPath {
+			return containers[i].LXCPath < containers[j].LXCPath
+		}
+		return containers[i].Name < containers[j].Name
+	})
+
+	info := &family{name: "lxc_container_info", help: "Container state, always 1.", kind: "gauge"}
+	running := &family{name: "lxc_container_running", help: "Whether the container is running.", kind: "gauge"}
+	initPID := &family{name: "lxc_container_init_pid", help: "PID of the container's init process.", kind: "gauge"}
+	memoryUsage := &family{name: "lxc_memory_usage_bytes", help: "Memory used by the container.", kind: "gauge"}
+	memoryLimit := &family{name: "lxc_memory_limit_bytes", help: "Memory limit of the container.", kind: "gauge"}
+	swapUsage := &family{name: "lxc_swap_usage_bytes", help: "Memory plus swap used by the container.", kind: "gauge"}
+	swapLimit := &family{name: "lxc_swap_limit_bytes", help: "Memory plus swap limit of the container.", kind: "gauge"}
+	cpuTime := &family{name: "lxc_cpu_seconds_total", help: "CPU time consumed by the container.", kind: "counter"}
+	readBytes := &family{name: "lxc_blkio_read_bytes_total", help: "Bytes read by the container per device.", kind: "counter"}
+	writeBytes := &family{name: "lxc_blkio_write_bytes_total", help: "Bytes written by the container per device.", kind: "counter"}
+	readOps := &family{name: "lxc_blkio_read_ops_total", help: "Read operations of the container per device.", kind: "counter"}
+	writeOps := &family{name: "lxc_blkio_write_ops_total", help: "Write operations of the container per device.", kind: "counter"}
+	rxBytes := &family{name: "lxc_network_receive_bytes_total", help: "Bytes received per interface.", kind: "counter"}
+	rxPackets := &family{name: "lxc_network_receive_packets_total", help: "Packets received per interface.", kind: "counter"}
+	rxErrors := &family{name: "lxc_network_receive_errors_total", help: "Receive errors per interface.", kind: "counter"}
+	rxDropped := &family{name: "lxc_network_receive_drops_total", help: "Received packets dropped per interface.", kind: "counter"}
+	txBytes := &family{name: "lxc_network_transmit_bytes_total", help: "Bytes transmitted per interface.", kind: "counter"}
+	txPackets := &family{name: "lxc_network_transmit_packets_total", help: "Packets transmitted per interface.", kind: "counter"}
+	txErrors := &family{name: "lxc_network_transmit_errors_total", help: "Transmit errors per interface.", kind: "counter"}
+	txDropped := &family{name: "lxc_network_transmit_drops_total", help: "Transmitted packets dropped per interface.", kind: "counter"}
+	pidsCurrent := &family{name: "lxc_pids_current", help: "Number of tasks in the container.", kind: "gauge"}
+	pidsLimit := &family{name: "lxc_pids_limit", help: "Task limit of the container.", kind: "gauge"}
+
+	for _, c := range containers {
+		labels := []string{"name", c.Name, "lxcpath", c.LXCPath}
+		info.add(1, withLabel(labels, "state", c.State)...)
+		running.add(boolValue(c.Running), labels...)
+		if !c.Running {
+			continue
+		}
+
+		initPID.add(float64(c.InitPID), labels...)
+		memoryUsage.add(float64(c.MemoryUsage), labels...)
+		addLimit(memoryLimit, c.MemoryLimit, labels)
+		swapUsage.add(float64(c.SwapUsage), labels...)
+		addLimit(swapLimit, c.SwapLimit, labels)
+		cpuTime.add(c.CPUTime.Seconds(), labels...)
+
+		for _, b := range c.BlockIO {
+			device := withLabel(labels, "device", b.Device)
+			readBytes.add(float64(b.ReadBytes), device...)
+			writeBytes.add(float64(b.WriteBytes), device...)
+			readOps.add(float64(b.ReadOps), device...)
+			writeOps.add(float64(b.WriteOps), device...)
+		}
+		for _, n := range c.Network {
+			iface := withLabel(labels, "interface", n.Interface)
+			rxBytes.add(float64(n.RxBytes), iface...)
+			rxPackets.add(float64(n.RxPackets), iface...)
+			rxErrors.add(float64(n.RxErrors), iface...)
+			rxDropped.add(float64(n.RxDropped), iface...)
+			txBytes.add(float64(n.TxBytes), iface...)
+			txPackets.add(float64(n.TxPackets), iface...)
+			txErrors.add(float64(n.TxErrors), iface...)
+			txDropped.add(float64(n.TxDropped), iface...)
+		}
+
+		pidsCurrent.add(float64(c.PidsCurrent), labels...)
+		addLimit(pidsLimit, c.PidsLimit, labels)
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, f := range []*family{
+		info, running, initPID,
+		memoryUsage, memoryLimit, swapUsage, swapLimit,
+		cpuTime,
+		readBytes, writeBytes, readOps, writeOps,
+		rxBytes, rxPackets, rxErrors, rxDropped, txBytes, txPackets, txErrors, txDropped,
+		pidsCurrent, pidsLimit,
+	} {
+		writeFamily(bw, f)
+	}
+	return bw.Flush()
+}
+
+func withLabel(labels []string, name string, value string) []string {
+	return append(append([]string(nil), labels...), name, value)
+}
+
+// limits without a value (negative or "max") are left out
+func addLimit(f *family, limit int64, labels []string) {
+	if limit < 0 || limit == math.MaxInt64 {
+		return
+	}
+	f.add(float64(limit), labels...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func writeFamily(w *bufio.Writer, f *family) {
+	if len(f.samples) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range f.samples {
+		w.WriteString(f.name)
+		if len(s.labels) > 0 {
+			w.WriteByte('{')
+			for i := 0; i+1 < len(s.labels); i += 2 {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", s.labels[i], escapeLabel(s.labels[i+1]))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatFloat(s.value, 'g', -1, 64))
+		w.WriteByte('\n')
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
@@ -0,0 +1,125 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package exporter
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeProvider struct {
+	containers []ContainerStats
+	err        error
+}
+
+func (p *fakeProvider) Containers() ([]ContainerStats, error) {
+	return p.containers, p.err
+}
+
+var containers = []ContainerStats{
+	{
+		Name:        "rubik",
+		LXCPath:     "/var/lib/lxc",
+		State:       "RUNNING",
+		Running:     true,
+		InitPID:     4242,
+		MemoryUsage: 1048576,
+		MemoryLimit: math.MaxInt64,
+		SwapUsage:   2097152,
+		SwapLimit:   -1,
+		CPUTime:     1500 * time.Millisecond,
+		BlockIO:     []BlockIO{{Device: "sda", ReadBytes: 4096, WriteBytes: 8192, ReadOps: 1, WriteOps: 2}},
+		Network:     []Network{{Interface: "eth0", RxBytes: 100, TxBytes: 200}},
+		PidsCurrent: 12,
+		PidsLimit:   100,
+	},
+	{
+		Name:    "stopped",
+		LXCPath: "/var/lib/lxc",
+		State:   "STOPPED",
+	},
+}
+
+func TestWriteMetrics(t *testing.T) {
+	var b strings.Builder
+	if err := WriteMetrics(&b, containers); err != nil {
+		t.Fatalf("WriteMetrics failed: %s", err)
+	}
+	metrics := b.String()
+
+	for _, line := range []string{
+		"# TYPE lxc_cpu_seconds_total counter",
+		`lxc_container_info{name="rubik",lxcpath="/var/lib/lxc",state="RUNNING"} 1`,
+		`lxc_container_running{name="stopped",lxcpath="/var/lib/lxc"} 0`,
+		`lxc_container_init_pid{name="rubik",lxcpath="/var/lib/lxc"} 4242`,
+		`lxc_memory_usage_bytes{name="rubik",lxcpath="/var/lib/lxc"} 1.048576e+06`,
+		`lxc_cpu_seconds_total{name="rubik",lxcpath="/var/lib/lxc"} 1.5`,
+		`lxc_blkio_write_bytes_total{name="rubik",lxcpath="/var/lib/lxc",device="sda"} 8192`,
+		`lxc_network_transmit_bytes_total{name="rubik",lxcpath="/var/lib/lxc",interface="eth0"} 200`,
+		`lxc_pids_limit{name="rubik",lxcpath="/var/lib/lxc"} 100`,
+	} {
+		if !strings.Contains(metrics, line+"\n") {
+			t.Errorf("WriteMetrics failed: %q is missing", line)
+		}
+	}
+
+	// no limits and stopped containers
+	for _, name := range []string{"lxc_memory_limit_bytes", "lxc_swap_limit_bytes", `name="stopped",lxcpath="/var/lib/lxc"} 1`} {
+		if strings.Contains(metrics, name) {
+			t.Errorf("WriteMetrics failed: unexpected %q", name)
+		}
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if escapeLabel("a\"b\\c\nd") != `a\"b\\c\nd` {
+		t.Errorf("escapeLabel failed: %s", escapeLabel("a\"b\\c\nd"))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	server := httptest.NewServer(NewHandler(&fakeProvider{containers: containers}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != contentType {
+		t.Errorf("Handler failed: %s %s", resp.Status, resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "lxc_pids_current") {
+		t.Errorf("Handler failed: %s", body)
+	}
+}
+
+func TestHandler_Error(t *testing.T) {
+	server := httptest.NewServer(NewHandler(&fakeProvider{err: errors.New("boom")}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Handler failed: %s", resp.Status)
+	}
+}
@@ -0,0 +1,633 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the kernel's overflowuid and overflowgid, shown for unmapped IDs
+const overflowID = 65534
+
+// User and group ID mappings of a container, both empty for privileged
+// containers
+type idMaps struct {
+	uids []idMapping
+	gids []idMapping
+}
+
+// Returns the ID mappings of the running container or the configured ones
+func (lxc *Container) idMaps() idMaps {
+	if lxc.running() {
+		pid := lxc.initPID()
+		uidMap, _ := os.ReadFile(fmt.Sprintf("/proc/%d/uid_map", pid))
+		gidMap, _ := os.ReadFile(fmt.Sprintf("/proc/%d/gid_map", pid))
+		return idMaps{uids: parseIDMap(string(uidMap)), gids: parseIDMap(string(gidMap))}
+	}
+
+	value, _, _ := lxc.configValue("lxc.idmap")
+	return parseConfigIDMap(value)
+}
+
+// Parses lxc.idmap values like "u 0 100000 65536", one per line
+func parseConfigIDMap(value string) idMaps {
+	var maps idMaps
+	for _, line := range strings.Split(value, "\n") {
+		kind, mapping, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		for _, m := range parseIDMap(mapping) {
+			switch kind {
+			case "u":
+				maps.uids = append(maps.uids, m)
+			case "g":
+				maps.gids = append(maps.gids, m)
+			case "b":
+				maps.uids = append(maps.uids, m)
+				maps.gids = append(maps.gids, m)
+			}
+		}
+	}
+	return maps
+}
+
+// Maps IDs of the container to the host
+func (m idMaps) toHost(uid int, gid int) (int, int, error) {
+	hostUID, hostGID := uid, gid
+	if len(m.uids) > 0 {
+		hostUID = hostID(m.uids, uid)
+	}
+	if len(m.gids) > 0 {
+		hostGID = hostID(m.gids, gid)
+	}
+	if hostUID == -1 || hostGID == -1 {
+		return -1, -1, fmt.Errorf("%w: %d:%d is not mapped to the host", ErrInvalidConfigValue, uid, gid)
+	}
+	return hostUID, hostGID, nil
+}
+
+// Maps IDs of the host to the container, unmapped ones become overflowID
+func (m idMaps) toContainer(uid int, gid int) (int, int) {
+	if len(m.uids) > 0 {
+		if uid = containerID(m.uids, uid); uid == -1 {
+			uid = overflowID
+		}
+	}
+	if len(m.gids) > 0 {
+		if gid = containerID(m.gids, gid); gid == -1 {
+			gid = overflowID
+		}
+	}
+	return uid, gid
+}
+
+// Maps a container ID to the host, -1 if it isn't mapped
+func hostID(mappings []idMapping, id int) int {
+	for _, m := range mappings {
+		if int64(id) >= m.inside && int64(id) < m.inside+m.count {
+			return int(m.outside + int64(id) - m.inside)
+		}
+	}
+	return -1
+}
+
+// Files of a container, written to the upper layer of its root
+type containerFiles struct {
+	root *rootFS
+	ids  idMaps
+	// whether char and block devices of tar streams are created, they give
+	// access to host devices the container couldn't create itself
+	devices bool
+}
+
+// The paths are collected under the lock, copying happens without it so that
+// long transfers don't block stopping the container
+func (lxc *Container) containerFiles() (*containerFiles, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+
+	root, err := lxc.containerRoot()
+	if err != nil {
+		return nil, err
+	}
+	return &containerFiles{root: root, ids: lxc.idMaps()}, nil
+}
+
+// Copies the host file to the container, creating missing directories. A zero
+// mode keeps the mode of the host file, uid and gid are IDs of the container.
+func (lxc *Container) PushFile(hostPath string, containerPath string, mode os.FileMode, uid int, gid int) error {
+	files, err := lxc.containerFiles()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if mode == 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		mode = fi.Mode()
+	}
+	return files.writeFile(containerPath, f, mode, uid, gid, time.Time{})
+}
+
+// Copies the container file to the host, symlinks are resolved inside the
+// container
+func (lxc *Container) PullFile(containerPath string, hostPath string) error {
+	files, err := lxc.containerFiles()
+	if err != nil {
+		return err
+	}
+
+	src, err := files.root.Open(containerName(containerPath))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return &fs.PathError{Op: "pull", Path: containerPath, Err: syscall.EISDIR}
+	}
+
+	dst, err := os.OpenFile(hostPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Extracts the tar stream into destDir of the container. The owners in the
+// stream are IDs of the container.
+func (lxc *Container) CopyIn(r io.Reader, destDir string) error {
+	files, err := lxc.containerFiles()
+	if err != nil {
+		return err
+	}
+	return files.copyIn(r, destDir)
+}
+
+func (f *containerFiles) copyIn(r io.Reader, destDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// cleaning the absolute path drops leading ..
+		err = f.extract(hdr, tr, path.Join("/", destDir, hdr.Name), path.Join("/", destDir, hdr.Linkname))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Creates the tar entry at the container path name, hard links point to the
+// container path link
+func (f *containerFiles) extract(hdr *tar.Header, r io.Reader, name string, link string) error {
+	mode := hdr.FileInfo().Mode()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return f.mkdir(name, mode, hdr.Uid, hdr.Gid)
+	case tar.TypeReg:
+		return f.writeFile(name, r, mode, hdr.Uid, hdr.Gid, hdr.ModTime)
+	case tar.TypeSymlink:
+		return f.symlink(name, hdr.Linkname, hdr.Uid, hdr.Gid)
+	case tar.TypeLink:
+		return f.link(name, link)
+	case tar.TypeChar, tar.TypeBlock:
+		if !f.devices {
+			return fmt.Errorf("%w: %s is a device", ErrNotSupported, hdr.Name)
+		}
+		return f.mknod(name, hdr)
+	case tar.TypeFifo:
+		return f.mknod(name, hdr)
+	case tar.TypeXGlobalHeader:
+		return nil
+	}
+	return fmt.Errorf("%w: %s is of tar type %q", ErrNotSupported, hdr.Name, hdr.Typeflag)
+}
+
+// Returns a tar stream of srcDir of the container, with the owners mapped to
+// IDs of the container
+func (lxc *Container) CopyOut(srcDir string) (io.ReadCloser, error) {
+	files, err := lxc.containerFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	src := containerName(srcDir)
+	if _, err := fs.Stat(files.root, src); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(files.writeTar(pw, src))
+	}()
+	return pr, nil
+}
+
+// Turns a container path into an fs.FS name
+func containerName(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (f *containerFiles) writeTar(w io.Writer, src string) error {
+	tw := tar.NewWriter(w)
+	if err := f.addTree(tw, src, treeOptions{}); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+type treeOptions struct {
+	// prepended to the names of the entries
+	prefix string
+	// fs.FS names left out
+	exclude map[string]bool
+	// sha256 of regular files keyed by entry names, if not nil
+	sums map[string]string
+}
+
+// Adds src of the container and everything below it to the tar stream
+func (f *containerFiles) addTree(tw *tar.Writer, src string, opts treeOptions) error {
+	return fs.WalkDir(f.root, src, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if opts.exclude[name] {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&fs.ModeSocket != 0 {
+			return nil
+		}
+
+		var link string
+		if fi.Mode()&fs.ModeSymlink != 0 {
+			if link, err = f.root.ReadLink(name); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+
+		rel := "."
+		if name != src {
+			rel = strings.TrimPrefix(name, src+"/")
+		}
+		hdr.Name = path.Join(opts.prefix, rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		// host names mean nothing in the container
+		hdr.Uname, hdr.Gname = "", ""
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			hdr.Uid, hdr.Gid = f.ids.toContainer(int(st.Uid), int(st.Gid))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		file, err := f.root.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		if opts.sums == nil {
+			_, err = io.Copy(tw, file)
+			return err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tw, h), file); err != nil {
+			return err
+		}
+		opts.sums[hdr.Name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+}
+
+// Opens dir in the upper layer, creating it and its missing parents owned by
+// the container's root. Everything below the layer is created relative to
+// descriptors of directories opened without following symlinks, so a running
+// container can't redirect the writes to the host by swapping components.
+func (f *containerFiles) mkdirAll(dir string) (*os.File, error) {
+	uid, gid, err := f.ids.toHost(0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := f.root.resolvePath(strings.TrimPrefix(dir, "/"), func(name string) error {
+		parent, err := f.copyUp(path.Dir(name))
+		if err != nil {
+			return err
+		}
+		defer parent.Close()
+		if err := mkdirAt(parent, path.Base(name), 0755); err != nil {
+			return err
+		}
+		return chownAt(parent, path.Base(name), uid, gid, 0755)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f.copyUp(resolved)
+}
+
+// Opens dir in the upper layer, creating the directories of lower overlay
+// layers in it like overlayfs does before modifying their contents
+func (f *containerFiles) copyUp(dir string) (*os.File, error) {
+	if len(f.root.layers) == 1 {
+		return openInRoot(f.root.layers[0], dir, oPath|syscall.O_DIRECTORY)
+	}
+	current, err := openInRoot(f.root.layers[0], "", oPath|syscall.O_DIRECTORY)
+	if err != nil {
+		return nil, err
+	}
+
+	name := "/"
+	for _, component := range strings.Split(strings.TrimPrefix(dir, "/"), "/") {
+		if component == "" {
+			continue
+		}
+		name = path.Join(name, component)
+
+		if _, err := lstatAt(current, component); errors.Is(err, fs.ErrNotExist) {
+			if err := f.copyUpDir(current, name); err != nil {
+				current.Close()
+				return nil, err
+			}
+		}
+
+		next, err := openAt(current, component, oPath|syscall.O_DIRECTORY)
+		current.Close()
+		if err != nil {
+			return nil, err
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// Creates the directory name of lower layers in parent, the upper layer's
+// directory of its parent, with the same mode and owner
+func (f *containerFiles) copyUpDir(parent *os.File, name string) error {
+	_, fi, err := f.root.lookup(name)
+	if err != nil {
+		return err
+	}
+	if err := mkdirAt(parent, path.Base(name), uint32(fi.Mode().Perm())); err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return chownAt(parent, path.Base(name), int(st.Uid), int(st.Gid), fi.Mode())
+	}
+	return nil
+}
+
+func (f *containerFiles) mkdir(name string, mode os.FileMode, uid int, gid int) error {
+	hostUID, hostGID, err := f.ids.toHost(uid, gid)
+	if err != nil {
+		return err
+	}
+	dir, err := f.mkdirAll(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return chownFile(dir, hostUID, hostGID, mode)
+}
+
+// Writes the file through a temporary one, so that existing files and
+// symlinks are replaced instead of written through. A zero modTime keeps the
+// time of writing.
+func (f *containerFiles) writeFile(name string, r io.Reader, mode os.FileMode, uid int, gid int, modTime time.Time) error {
+	hostUID, hostGID, err := f.ids.toHost(uid, gid)
+	if err != nil {
+		return err
+	}
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return &fs.PathError{Op: "write", Path: name, Err: syscall.EISDIR}
+	}
+	dir, err := f.mkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	tmp, err := createTempAt(dir, ".lxc-")
+	if err != nil {
+		return err
+	}
+	tmpName := path.Base(tmp.Name())
+	if err := writeTemp(tmp, r, hostUID, hostGID, mode, modTime); err != nil {
+		unlinkAt(dir, tmpName)
+		return err
+	}
+	if err := renameAt(dir, tmpName, path.Base(name)); err != nil {
+		unlinkAt(dir, tmpName)
+		return err
+	}
+	return nil
+}
+
+// Fills and closes the temporary file of writeFile
+func writeTemp(tmp *os.File, r io.Reader, uid int, gid int, mode os.FileMode, modTime time.Time) error {
+	_, err := io.Copy(tmp, r)
+	if err == nil {
+		err = chownFile(tmp, uid, gid, mode)
+	}
+	if err == nil && !modTime.IsZero() {
+		tv := syscall.NsecToTimeval(modTime.UnixNano())
+		if err = syscall.Futimes(int(tmp.Fd()), []syscall.Timeval{tv, tv}); err != nil {
+			err = &fs.PathError{Op: "utimes", Path: tmp.Name(), Err: err}
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (f *containerFiles) symlink(name string, target string, uid int, gid int) error {
+	hostUID, hostGID, err := f.ids.toHost(uid, gid)
+	if err != nil {
+		return err
+	}
+	name = path.Clean(name)
+	dir, err := f.mkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	tmp := ".lxc-link-" + path.Base(name)
+	unlinkAt(dir, tmp)
+	if err := symlinkAt(target, dir, tmp); err != nil {
+		return err
+	}
+	if err := chownAt(dir, tmp, hostUID, hostGID, 0); err != nil {
+		unlinkAt(dir, tmp)
+		return err
+	}
+	if err := renameAt(dir, tmp, path.Base(name)); err != nil {
+		unlinkAt(dir, tmp)
+		return err
+	}
+	return nil
+}
+
+// Creates name as a hard link to target, both being container paths
+func (f *containerFiles) link(name string, target string) error {
+	layer, resolved, fi, err := f.root.lookupNoFollow("link", containerName(target))
+	if err != nil {
+		return err
+	}
+	if layer != f.root.layers[0] {
+		// linking the lower layer's file would let writes through the link
+		// modify the layer other containers share
+		if err := f.copyUpFile(layer, resolved, fi); err != nil {
+			return err
+		}
+		layer = f.root.layers[0]
+	}
+	src, err := openInRoot(layer, path.Dir("/"+resolved), oPath|syscall.O_DIRECTORY)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	name = path.Clean(name)
+	dir, err := f.mkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if err := unlinkAt(dir, path.Base(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return linkAt(src, path.Base(resolved), dir, path.Base(name))
+}
+
+// Copies the regular file name of the lower layer to the upper one, keeping
+// its mode, owner and modification time
+func (f *containerFiles) copyUpFile(layer string, name string, fi fs.FileInfo) error {
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%w: hard link to the lower layer %s %s", ErrNotSupported, fi.Mode().Type(), name)
+	}
+	uid, gid := -1, -1
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		uid, gid = int(st.Uid), int(st.Gid)
+	}
+
+	src, err := openInRoot(layer, name, syscall.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dir, err := f.copyUp(path.Dir("/" + name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	tmp, err := createTempAt(dir, ".lxc-")
+	if err != nil {
+		return err
+	}
+	tmpName := path.Base(tmp.Name())
+	if err := writeTemp(tmp, src, uid, gid, fi.Mode(), fi.ModTime()); err != nil {
+		unlinkAt(dir, tmpName)
+		return err
+	}
+	if err := renameAt(dir, tmpName, path.Base(name)); err != nil {
+		unlinkAt(dir, tmpName)
+		return err
+	}
+	return nil
+}
+
+// Creates the device node or fifo of the tar entry at the container path name
+func (f *containerFiles) mknod(name string, hdr *tar.Header) error {
+	hostUID, hostGID, err := f.ids.toHost(hdr.Uid, hdr.Gid)
+	if err != nil {
+		return err
+	}
+	name = path.Clean(name)
+	dir, err := f.mkdirAll(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	base := path.Base(name)
+	if err := unlinkAt(dir, base); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	kind := map[byte]uint32{tar.TypeChar: syscall.S_IFCHR, tar.TypeBlock: syscall.S_IFBLK, tar.TypeFifo: syscall.S_IFIFO}[hdr.Typeflag]
+	if err := mknodAt(dir, base, kind|0600, makedev(hdr.Devmajor, hdr.Devminor)); err != nil {
+		return err
+	}
+	return chownAt(dir, base, hostUID, hostGID, hdr.FileInfo().Mode())
+}
+
+// Encodes a device number like glibc's makedev
+func makedev(major int64, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
@@ -0,0 +1,449 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// names below <lxcpath>/.images, each image has a directory named by its ID
+const (
+	imagesDir     = ".images"
+	imagesLock    = "lock"
+	imageRootFS   = "rootfs"
+	imageMetadata = "metadata.json"
+)
+
+// Rootfs tarballs cached below <lxcpath>/.images, stored by their sha256 so
+// that identical tarballs are kept once. The store is locked with flock, so
+// it is safe to use from several processes.
+type ImageStore struct {
+	dir string
+}
+
+// Describes a rootfs tarball added to the store
+type ImageMetadata struct {
+	Distro  string `json:"distro"`
+	Release string `json:"release"`
+	// LXC architecture of the rootfs, like "amd64", set as lxc.arch of the
+	// containers created from it
+	Arch string `json:"arch"`
+	// when the rootfs was built, when it was added if zero
+	Created time.Time `json:"created"`
+}
+
+// A rootfs tarball of the store
+type Image struct {
+	ImageMetadata
+	// hex encoded sha256 of the tarball
+	ID    string    `json:"id"`
+	Size  int64     `json:"size"`
+	Added time.Time `json:"added"`
+	// when a container was last created from the image, zero if never
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Returns the image store of lxcpath, the default one if empty
+func NewImageStore(lxcpath string) (*ImageStore, error) {
+	if lxcpath == "" {
+		lxcpath = DefaultConfigPath()
+	}
+	dir := filepath.Join(lxcpath, imagesDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &ImageStore{dir: dir}, nil
+}
+
+// Takes the store's lock, shared for reading. The returned function releases
+// it.
+func (s *ImageStore) lock(exclusive bool) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(s.dir, imagesLock), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	for {
+		if err = syscall.Flock(int(f.Fd()), how); err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, &fs.PathError{Op: "flock", Path: f.Name(), Err: err}
+	}
+	return func() { f.Close() }, nil
+}
+
+// Adds the rootfs tarball read from r, plain, gzip or bzip2 compressed. The
+// store's image is returned if the tarball is already stored.
+func (s *ImageStore) Add(r io.Reader, meta ImageMetadata) (*Image, error) {
+	// copied without the lock, so that slow readers don't block the store
+	tmp, err := os.CreateTemp(s.dir, ".add-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+	if err := checkTarball(tmp.Name()); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	image := &Image{ImageMetadata: meta, ID: hex.EncodeToString(h.Sum(nil)), Size: size, Added: now}
+	if image.Created.IsZero() {
+		image.Created = now
+	}
+
+	unlock, err := s.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if existing, err := s.image(image.ID); err == nil {
+		return existing, nil
+	}
+	dir := filepath.Join(s.dir, image.ID)
+	// leftovers of an interrupted Add
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, imageRootFS)); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := s.writeMetadata(image); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return image, nil
+}
+
+// Reads the whole tarball, so that broken or unsupported ones aren't stored
+func checkTarball(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := copyTarball(tar.NewWriter(io.Discard), f); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	return nil
+}
+
+// The metadata is written last, images without it are incomplete
+func (s *ImageStore) writeMetadata(image *Image) error {
+	data, err := json.MarshalIndent(image, "", "  ")
+	if err != nil {
+		return err
+	}
+	name := filepath.Join(s.dir, image.ID, imageMetadata)
+	if err := os.WriteFile(name+".tmp", append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(name+".tmp", name)
+}
+
+func (s *ImageStore) image(id string) (*Image, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, id, imageMetadata))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	image := &Image{}
+	if err := json.Unmarshal(data, image); err != nil {
+		return nil, fmt.Errorf("image %s: %w", id, err)
+	}
+	return image, nil
+}
+
+// Returns the images of the store, oldest first
+func (s *ImageStore) List() ([]Image, error) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.list()
+}
+
+func (s *ImageStore) list() ([]Image, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var images []Image
+	for _, entry := range entries {
+		if !entry.IsDir() || !isImageID(entry.Name()) {
+			continue
+		}
+		image, err := s.image(entry.Name())
+		if errors.Is(err, ErrImageNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, *image)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if !images[i].Created.Equal(images[j].Created) {
+			return images[i].Created.Before(images[j].Created)
+		}
+		return images[i].ID < images[j].ID
+	})
+	return images, nil
+}
+
+func isImageID(name string) bool {
+	b, err := hex.DecodeString(name)
+	return err == nil && len(b) == sha256.Size
+}
+
+// Returns the image of id, which may be an unambiguous prefix and may start
+// with "sha256:"
+func (s *ImageStore) Get(id string) (*Image, error) {
+	unlock, err := s.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return s.lookup(id)
+}
+
+func (s *ImageStore) lookup(id string) (*Image, error) {
+	id = strings.TrimPrefix(id, "sha256:")
+	if id == "" {
+		return nil, fmt.Errorf("%w: empty id", ErrImageNotFound)
+	}
+	images, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	var found *Image
+	for i := range images {
+		if !strings.HasPrefix(images[i].ID, id) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%w: %s", ErrAmbiguousImage, id)
+		}
+		found = &images[i]
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, id)
+	}
+	return found, nil
+}
+
+// Returns the newest image of distro, release and arch, empty values match
+// any image
+func (s *ImageStore) Find(distro string, release string, arch string) (*Image, error) {
+	images, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(images) - 1; i >= 0; i-- {
+		image := images[i]
+		if (distro == "" || image.Distro == distro) && (release == "" || image.Release == release) && (arch == "" || image.Arch == arch) {
+			return &image, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s %s %s", ErrImageNotFound, distro, release, arch)
+}
+
+// Removes the image of id, containers created from it are left untouched
+func (s *ImageStore) Remove(id string) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	image, err := s.lookup(id)
+	if err != nil {
+		return err
+	}
+	return s.remove(image.ID)
+}
+
+func (s *ImageStore) remove(id string) error {
+	// readers skip images without metadata
+	if err := os.Remove(filepath.Join(s.dir, id, imageMetadata)); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.dir, id))
+}
+
+// Removes the images no container was created from within unusedFor, counted
+// from when they were added if they were never used, and leftovers of
+// interrupted adds. Returns the removed images.
+func (s *ImageStore) Prune(unusedFor time.Duration) ([]Image, error) {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !isImageID(entry.Name()) {
+			continue
+		}
+		_, err := os.Stat(filepath.Join(s.dir, entry.Name(), imageMetadata))
+		if errors.Is(err, fs.ErrNotExist) {
+			if err := os.RemoveAll(filepath.Join(s.dir, entry.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	images, err := s.list()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-unusedFor)
+	var removed []Image
+	for _, image := range images {
+		used := image.LastUsed
+		if used.IsZero() {
+			used = image.Added
+		}
+		if !used.Before(cutoff) {
+			continue
+		}
+		if err := s.remove(image.ID); err != nil {
+			return removed, err
+		}
+		removed = append(removed, image)
+	}
+	return removed, nil
+}
+
+// Returns a Template populating the rootfs from the image of id. The tarball
+// is verified against its sha256 while it is extracted.
+func (s *ImageStore) Template(id string) (Template, error) {
+	image, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return &imageTemplate{store: s, image: image}, nil
+}
+
+type imageTemplate struct {
+	store *ImageStore
+	image *Image
+}
+
+// Populate implements Template
+func (t *imageTemplate) Populate(tw *tar.Writer) error {
+	unlock, err := t.store.lock(false)
+	if err != nil {
+		return err
+	}
+	// the open file outlives removing the image
+	f, err := os.Open(filepath.Join(t.store.dir, t.image.ID, imageRootFS))
+	unlock()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if err := copyTarball(tw, io.TeeReader(f, h)); err != nil {
+		return fmt.Errorf("image %s: %w", t.image.ID, err)
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != t.image.ID {
+		return fmt.Errorf("%w: image %s", ErrChecksumMismatch, t.image.ID)
+	}
+	return nil
+}
+
+// Configure implements Template
+func (t *imageTemplate) Configure(tx *ConfigTx) error {
+	if t.image.Arch != "" {
+		tx.Set("lxc.arch", t.image.Arch)
+	}
+	return nil
+}
+
+// Records that a container was created from the image of id
+func (s *ImageStore) touch(id string) error {
+	unlock, err := s.lock(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	image, err := s.image(id)
+	if errors.Is(err, ErrImageNotFound) {
+		// removed meanwhile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	image.LastUsed = time.Now().UTC()
+	return s.writeMetadata(image)
+}
+
+// Creates the container from the image of id of the store, like
+// CreateFromTemplate, and records the image as used. The configuration LXC
+// ships for the image's distribution is included if present.
+func (lxc *Container) CreateFromImage(store *ImageStore, id string, opts TemplateOptions) error {
+	t, err := store.Template(id)
+	if err != nil {
+		return err
+	}
+	image := t.(*imageTemplate).image
+	if err := lxc.createFromTemplate(t, opts, image.Distro); err != nil {
+		return err
+	}
+	return store.touch(image.ID)
+}
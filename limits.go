@@ -0,0 +1,318 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	minCPUShares = 2
+	maxCPUShares = 262144
+	minCPUWeight = 1
+	maxCPUWeight = 10000
+
+	minCPUPeriod = time.Millisecond
+	maxCPUPeriod = time.Second
+	minCPUQuota  = time.Millisecond
+)
+
+// a single cgroup file to write, per device files (like io.max) keep the
+// configured values of other devices
+type cgroupSetting struct {
+	key    string
+	value  string
+	device string
+}
+
+// Sets the memory limit of the container
+func (lxc *Container) SetMemoryLimit(limit ByteSize) error {
+	if limit <= 0 {
+		return &InvalidLimitError{Limit: "memory", Value: limit.String(), Reason: "must be positive"}
+	}
+
+	key := "memory.limit_in_bytes"
+	if unifiedCgroup() {
+		key = "memory.max"
+	}
+	return lxc.setCgroupLimits(cgroupSetting{key: key, value: formatBytes(limit)})
+}
+
+// Sets the memory plus swap limit of the container, like
+// memory.memsw.limit_in_bytes does. On cgroup v2 hosts, where swap is limited
+// on its own, the memory limit has to be set first.
+func (lxc *Container) SetSwapLimit(limit ByteSize) error {
+	if limit <= 0 {
+		return &InvalidLimitError{Limit: "swap", Value: limit.String(), Reason: "must be positive"}
+	}
+	if !unifiedCgroup() {
+		return lxc.setCgroupLimits(cgroupSetting{key: "memory.memsw.limit_in_bytes", value: formatBytes(limit)})
+	}
+
+	memoryLimit, err := lxc.configuredMemoryLimit()
+	if err != nil {
+		return err
+	}
+	if memoryLimit == ByteSize(math.MaxInt64) {
+		return &InvalidLimitError{Limit: "swap", Value: limit.String(), Reason: "memory limit is not set"}
+	}
+	if limit < memoryLimit {
+		return &InvalidLimitError{Limit: "swap", Value: limit.String(), Reason: "must not be lower than the memory limit"}
+	}
+	return lxc.setCgroupLimits(cgroupSetting{key: "memory.swap.max", value: formatBytes(limit - memoryLimit)})
+}
+
+// Returns the live memory limit of a running container or the configured one
+func (lxc *Container) configuredMemoryLimit() (ByteSize, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+
+	if lxc.running() {
+		return lxc.memoryLimit()
+	}
+	value, ok, err := lxc.configValue("lxc.cgroup2.memory.max")
+	if err != nil || !ok {
+		return ByteSize(math.MaxInt64), err
+	}
+	limit, err := parseConfigBytes(value)
+	if err != nil {
+		return 0, configValueError("lxc.cgroup2.memory.max", value)
+	}
+	return limit, nil
+}
+
+// Parses a memory limit config value like 512M, "max" meaning no limit
+func parseConfigBytes(value string) (ByteSize, error) {
+	if value == "max" {
+		return ByteSize(math.MaxInt64), nil
+	}
+	return ParseByteSize(value)
+}
+
+// Sets the relative CPU share of the container (2-262144, 1024 being the
+// default). On cgroup v2 hosts the value is converted to cpu.weight.
+func (lxc *Container) SetCPUShares(shares uint64) error {
+	if shares < minCPUShares || shares > maxCPUShares {
+		return &InvalidLimitError{Limit: "cpu shares", Value: strconv.FormatUint(shares, 10),
+			Reason: fmt.Sprintf("must be between %d and %d", minCPUShares, maxCPUShares)}
+	}
+
+	if unifiedCgroup() {
+		return lxc.setCgroupLimits(cgroupSetting{key: "cpu.weight", value: strconv.FormatUint(sharesToWeight(shares), 10)})
+	}
+	return lxc.setCgroupLimits(cgroupSetting{key: "cpu.shares", value: strconv.FormatUint(shares, 10)})
+}
+
+// Sets the relative CPU weight of the container (1-10000, 100 being the
+// default). On cgroup v1 hosts the value is converted to cpu.shares.
+func (lxc *Container) SetCPUWeight(weight uint64) error {
+	if weight < minCPUWeight || weight > maxCPUWeight {
+		return &InvalidLimitError{Limit: "cpu weight", Value: strconv.FormatUint(weight, 10),
+			Reason: fmt.Sprintf("must be between %d and %d", minCPUWeight, maxCPUWeight)}
+	}
+
+	if unifiedCgroup() {
+		return lxc.setCgroupLimits(cgroupSetting{key: "cpu.weight", value: strconv.FormatUint(weight, 10)})
+	}
+	return lxc.setCgroupLimits(cgroupSetting{key: "cpu.shares", value: strconv.FormatUint(weightToShares(weight), 10)})
+}
+
+// conversions between cpu.shares and cpu.weight, as done by the kernel and
+// container runtimes
+func sharesToWeight(shares uint64) uint64 {
+	return 1 + ((shares-minCPUShares)*(maxCPUWeight-1))/(maxCPUShares-minCPUShares)
+}
+
+func weightToShares(weight uint64) uint64 {
+	return minCPUShares + ((weight-1)*(maxCPUShares-minCPUShares))/(maxCPUWeight-1)
+}
+
+// Limits the container to quota CPU time in every period. A negative quota
+// removes the limit.
+func (lxc *Container) SetCPUQuota(period time.Duration, quota time.Duration) error {
+	if period < minCPUPeriod || period > maxCPUPeriod {
+		return &InvalidLimitError{Limit: "cpu period", Value: period.String(),
+			Reason: fmt.Sprintf("must be between %s and %s", minCPUPeriod, maxCPUPeriod)}
+	}
+	if quota >= 0 && quota < minCPUQuota {
+		return &InvalidLimitError{Limit: "cpu quota", Value: quota.String(),
+			Reason: fmt.Sprintf("must be at least %s or negative", minCPUQuota)}
+	}
+
+	periodUsec := strconv.FormatInt(period.Microseconds(), 10)
+	quotaUsec := "-1"
+	if quota >= 0 {
+		quotaUsec = strconv.FormatInt(quota.Microseconds(), 10)
+	}
+
+	if unifiedCgroup() {
+		if quota < 0 {
+			quotaUsec = "max"
+		}
+		return lxc.setCgroupLimits(cgroupSetting{key: "cpu.max", value: quotaUsec + " " + periodUsec})
+	}
+	return lxc.setCgroupLimits(
+		cgroupSetting{key: "cpu.cfs_period_us", value: periodUsec},
+		cgroupSetting{key: "cpu.cfs_quota_us", value: quotaUsec},
+	)
+}
+
+// Restricts the container to the given CPUs and memory nodes, both in list
+// format like "0-3,6". Empty values are left untouched.
+func (lxc *Container) SetCPUSet(cpus string, mems string) error {
+	var settings []cgroupSetting
+
+	if cpus != "" {
+		if !validCPUList(cpus) {
+			return &InvalidLimitError{Limit: "cpuset cpus", Value: cpus, Reason: "not a list like 0-3,6"}
+		}
+		settings = append(settings, cgroupSetting{key: "cpuset.cpus", value: cpus})
+	}
+	if mems != "" {
+		if !validCPUList(mems) {
+			return &InvalidLimitError{Limit: "cpuset mems", Value: mems, Reason: "not a list like 0-1"}
+		}
+		settings = append(settings, cgroupSetting{key: "cpuset.mems", value: mems})
+	}
+	if len(settings) == 0 {
+		return nil
+	}
+	return lxc.setCgroupLimits(settings...)
+}
+
+func validCPUList(list string) bool {
+	for _, part := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		start, err := strconv.ParseUint(first, 10, 32)
+		if err != nil {
+			return false
+		}
+		if !isRange {
+			continue
+		}
+		end, err := strconv.ParseUint(last, 10, 32)
+		if err != nil || end < start {
+			return false
+		}
+	}
+	return true
+}
+
+// Limits the number of tasks in the container, a negative value removes the
+// limit
+func (lxc *Container) SetPidsLimit(limit int64) error {
+	if limit == 0 {
+		return &InvalidLimitError{Limit: "pids", Value: "0", Reason: "must be positive or negative for no limit"}
+	}
+
+	value := strconv.FormatInt(limit, 10)
+	if limit < 0 {
+		value = "max"
+	}
+	return lxc.setCgroupLimits(cgroupSetting{key: "pids.max", value: value})
+}
+
+func formatBytes(size ByteSize) string {
+	return strconv.FormatInt(int64(size), 10)
+}
+
+// Applies the settings to the cgroup of a running container and stores them
+// as lxc.cgroup.* (or lxc.cgroup2.*) keys in its configuration file. The
+// previous live values are restored if storing the settings fails.
+func (lxc *Container) setCgroupLimits(settings ...cgroupSetting) error {
+	lxc.mu.Lock()
+	defer lxc.mu.Unlock()
+
+	prefix := "lxc.cgroup."
+	if unifiedCgroup() {
+		prefix = "lxc.cgroup2."
+	}
+	tx := &ConfigTx{}
+	for _, s := range settings {
+		key := prefix + s.key
+
+		var values []string
+		if s.device != "" {
+			if current, ok, _ := lxc.configValue(key); ok {
+				for _, v := range strings.Split(current, "\n") {
+					if !strings.HasPrefix(v, s.device+" ") {
+						values = append(values, v)
+					}
+				}
+			}
+		}
+		values = append(values, s.value)
+
+		// setting a cgroup key adds another entry, drop the previous ones
+		tx.Clear(key)
+		for _, v := range values {
+			tx.Set(key, v)
+		}
+	}
+	if err := tx.validate(); err != nil {
+		return err
+	}
+
+	var applied []cgroupSetting
+	if lxc.running() {
+		for _, s := range settings {
+			previous := cgroupSetting{key: s.key, value: lxc.liveCgroupValue(s), device: s.device}
+			if !lxc.setCgroupItem(s.key, s.value) {
+				err := fmt.Errorf("%w: %s = %s", ErrSettingCgroupItem, s.key, s.value)
+				return errors.Join(err, lxc.restoreCgroupLimits(applied))
+			}
+			applied = append(applied, previous)
+		}
+	}
+
+	// not saved if the container isn't created yet, the limits are saved
+	// along with it
+	_, err := os.Stat(lxc.configFileName())
+	if err := lxc.commitConfig(tx, !os.IsNotExist(err)); err != nil {
+		return errors.Join(err, lxc.restoreCgroupLimits(applied))
+	}
+	return nil
+}
+
+// Returns the live value of the setting's key, for device settings the line
+// of its device or the one removing its limits
+func (lxc *Container) liveCgroupValue(s cgroupSetting) string {
+	lines := lxc.cgroupItem(s.key)
+	if s.device == "" {
+		return strings.Join(lines, "\n")
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, s.device+" ") {
+			return line
+		}
+	}
+	if s.key == "io.max" {
+		return s.device + " rbps=max wbps=max riops=max wiops=max"
+	}
+	return s.device + " 0"
+}
+
+// Sets the previous live values again, in reverse order
+func (lxc *Container) restoreCgroupLimits(previous []cgroupSetting) error {
+	var errs []error
+	for i := len(previous) - 1; i >= 0; i-- {
+		s := previous[i]
+		if s.value != "" && !lxc.setCgroupItem(s.key, s.value) {
+			errs = append(errs, fmt.Errorf("%w: %s = %s", ErrSettingCgroupItem, s.key, s.value))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -75,7 +75,8 @@ bool lxc_container_wait(struct lxc_container *c, char *state, int timeout) {
 
 char* lxc_container_get_config_item(struct lxc_container *c, char *key) {
 	int len = c->get_config_item(c, key, NULL, 0);
-	if (len <= 0) {
+	// an empty string means the key is not set, NULL means the lookup failed
+	if (len < 0) {
 		return NULL;
 	}
 
@@ -36,10 +36,18 @@ func init() {
 	}
 }
 
-func NewContainer(name string) *Container {
+// Returns the container with the given name, from the given lxcpath or the
+// default one
+func NewContainer(name string, lxcpath ...string) *Container {
 	cname := C.CString(name)
 	defer C.free(unsafe.Pointer(cname))
-	return &Container{container: C.lxc_container_new(cname, nil)}
+
+	if len(lxcpath) == 0 || lxcpath[0] == "" {
+		return &Container{container: C.lxc_container_new(cname, nil)}
+	}
+	cpath := C.CString(lxcpath[0])
+	defer C.free(unsafe.Pointer(cpath))
+	return &Container{container: C.lxc_container_new(cname, cpath)}
 }
 
 // Increments reference counter of the container object
@@ -62,10 +70,15 @@ func DefaultConfigPath() string {
 	return C.GoString(C.lxc_get_default_config_path())
 }
 
-// Returns the names of containers on the system.
-func ContainerNames() []string {
-	// FIXME: Support custom config paths
-	matches, err := filepath.Glob(filepath.Join(DefaultConfigPath(), "/*/config"))
+// Returns the names of containers on the system, in the given lxcpath or the
+// default one.
+func ContainerNames(lxcpath ...string) []string {
+	path := DefaultConfigPath()
+	if len(lxcpath) > 0 && lxcpath[0] != "" {
+		path = lxcpath[0]
+	}
+
+	matches, err := filepath.Glob(filepath.Join(path, "/*/config"))
 	if err != nil {
 		return nil
 	}
@@ -76,12 +89,13 @@ func ContainerNames() []string {
 	return matches
 }
 
-// Returns the containers on the system.
-func Containers() []Container {
+// Returns the containers on the system, in the given lxcpath or the default
+// one.
+func Containers(lxcpath ...string) []Container {
 	var containers []Container
 
-	for _, v := range ContainerNames() {
-		containers = append(containers, *NewContainer(v))
+	for _, v := range ContainerNames(lxcpath...) {
+		containers = append(containers, *NewContainer(v, lxcpath...))
 	}
 	return containers
 }
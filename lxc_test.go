@@ -10,12 +10,25 @@
 package lxc
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"math"
 	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -36,6 +49,953 @@ func TestVersion(t *testing.T) {
 	}
 }
 
+func TestParseByteSize(t *testing.T) {
+	sizes := map[string]ByteSize{
+		"1024":   1024,
+		"512K":   512 * KB,
+		"512kB":  512 * KB,
+		"1.5GiB": 1.5 * GB,
+		"2 M":    2 * MB,
+	}
+	for s, expected := range sizes {
+		if size, err := ParseByteSize(s); err != nil || size != expected {
+			t.Errorf("ParseByteSize(%q) failed...", s)
+		}
+	}
+
+	for _, s := range []string{"", "M", "12X", "1.2.3K"} {
+		if _, err := ParseByteSize(s); err == nil {
+			t.Errorf("ParseByteSize(%q) failed...", s)
+		}
+	}
+}
+
+func TestConfigKeyTranslation(t *testing.T) {
+	keys := map[string]string{
+		"lxc.utsname":            "lxc.uts.name",
+		"lxc.rootfs":             "lxc.rootfs.path",
+		"lxc.id_map":             "lxc.idmap",
+		"lxc.network":            "lxc.net",
+		"lxc.network.0.link":     "lxc.net.0.link",
+		"lxc.network.1.ipv4":     "lxc.net.1.ipv4.address",
+		"lxc.limit.nofile":       "lxc.prlimit.nofile",
+		"lxc.rootfs.mount":       "lxc.rootfs.mount",
+		"lxc.cgroup.cpuset.cpus": "lxc.cgroup.cpuset.cpus",
+	}
+	for legacy, modern := range keys {
+		if modernConfigKey(legacy) != modern || legacyConfigKey(modern) != legacy {
+			t.Errorf("Translating %s failed...", legacy)
+		}
+	}
+	// unindexed keys belong to the first interface
+	if key := modernConfigKey("lxc.network.type"); key != "lxc.net.0.type" {
+		t.Errorf("Translating lxc.network.type failed: %s", key)
+	}
+}
+
+func TestMigrateConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config := "# network\n" +
+		"lxc.network.type = veth\n" +
+		"lxc.network.link = lxcbr0\n" +
+		"lxc.network.type = empty\n" +
+		"lxc.utsname = rubik\n" +
+		"lxc.mount.entry = proc proc proc nodev 0 0\n"
+	expected := "# network\n" +
+		"lxc.net.0.type = veth\n" +
+		"lxc.net.0.link = lxcbr0\n" +
+		"lxc.net.1.type = empty\n" +
+		"lxc.uts.name = rubik\n" +
+		"lxc.mount.entry = proc proc proc nodev 0 0\n"
+	if err := os.WriteFile(path, []byte(config), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	migration, err := MigrateConfig(path, true)
+	if err != nil || len(migration.Changes) != 4 {
+		t.Errorf("MigrateConfig failed...")
+	}
+	if data, _ := os.ReadFile(path); string(data) != config {
+		t.Errorf("MigrateConfig modified the config in dry run mode...")
+	}
+
+	if _, err := MigrateConfig(path, false); err != nil {
+		t.Errorf("MigrateConfig failed...")
+	}
+	if data, _ := os.ReadFile(path); string(data) != expected {
+		t.Errorf("MigrateConfig failed: %s", data)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	config := "lxc.utsname = rubik\n" +
+		"lxc.rootfs.path = /var/lib/lxc/rubik/rootfs\n" +
+		"lxc.net.0.type = veth\n" +
+		"lxc.net.0.mtu = big\n" +
+		"lxc.net.1.type = macvlan\n" +
+		"lxc.no.such.key = 1\n" +
+		"lxc.arch = i386\n" +
+		"lxc.log.level = debug\n" +
+		"lxc.mount.auto = proc:mixed shmounts:/var/lib/shmounts:/shmounts\n" +
+		"lxc.namespace.share.net = rubik\n" +
+		"lxc.start.timeout = 10\n" +
+		"lxc.seccomp.allow_nesting = 1\n"
+	if err := os.WriteFile(path, []byte(config), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	diagnostics, err := ValidateConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		line     int
+		severity ConfigSeverity
+	}{
+		{1, CONFIG_WARNING},
+		{3, CONFIG_WARNING},
+		{4, CONFIG_ERROR},
+		{5, CONFIG_ERROR},
+		{6, CONFIG_WARNING},
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("ValidateConfig failed: %v", diagnostics)
+	}
+	for i, e := range expected {
+		if diagnostics[i].Line != e.line || diagnostics[i].Severity != e.severity {
+			t.Errorf("ValidateConfig failed: %s", diagnostics[i])
+		}
+	}
+}
+
+func TestSetConfigItem_Invalid(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	if z.SetConfigItem("lxc.tty", "many") {
+		t.Errorf("SetConfigItem accepted an invalid value...")
+	}
+}
+
+func TestDecodeCapabilities(t *testing.T) {
+	caps := decodeCapabilities(1<<0 | 1<<21)
+	if len(caps) != 2 || caps[0] != "chown" || caps[1] != "sys_admin" {
+		t.Errorf("decodeCapabilities failed: %v", caps)
+	}
+}
+
+func TestDetectCgroupVersion(t *testing.T) {
+	if DetectCgroupVersion().String() == "<INVALID>" {
+		t.Errorf("DetectCgroupVersion failed...")
+	}
+}
+
+func TestParseCgroupStats(t *testing.T) {
+	stats, err := parseCgroupStats([]string{"usage_usec 1500", "user_usec 1000", "system_usec 500"})
+	if err != nil || stats["usage_usec"] != 1500 || stats["system_usec"] != 500 {
+		t.Errorf("parseCgroupStats failed...")
+	}
+
+	if size, err := parseCgroupBytes("max"); err != nil || size <= 0 {
+		t.Errorf("parseCgroupBytes failed...")
+	}
+	if size, err := parseConfigBytes("512M"); err != nil || size != 512*MB {
+		t.Errorf("parseConfigBytes failed...")
+	}
+	if size, err := parseConfigBytes("max"); err != nil || size != ByteSize(math.MaxInt64) {
+		t.Errorf("parseConfigBytes failed...")
+	}
+}
+
+func TestLimitConversions(t *testing.T) {
+	if sharesToWeight(1024) != 39 || sharesToWeight(2) != 1 || sharesToWeight(262144) != 10000 {
+		t.Errorf("sharesToWeight failed...")
+	}
+	if weightToShares(100) != 2597 || weightToShares(1) != 2 || weightToShares(10000) != 262144 {
+		t.Errorf("weightToShares failed...")
+	}
+
+	for _, list := range []string{"0", "0-3", "0-3,6,8-9"} {
+		if !validCPUList(list) {
+			t.Errorf("validCPUList(%q) failed...", list)
+		}
+	}
+	for _, list := range []string{"", "a", "3-1", "0,", "-1"} {
+		if validCPUList(list) {
+			t.Errorf("validCPUList(%q) failed...", list)
+		}
+	}
+}
+
+func TestParseBlockIOStats(t *testing.T) {
+	stats, err := parseIOStat([]string{"8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0"})
+	if err != nil || len(stats) != 1 {
+		t.Fatalf("parseIOStat failed...")
+	}
+	if stats[0].Major != 8 || stats[0].ReadBytes != 4096 || stats[0].WriteOps != 2 {
+		t.Errorf("parseIOStat failed: %+v", stats[0])
+	}
+
+	stats, err = parseBlkioStats(
+		[]string{"8:16 Read 10", "8:16 Write 20", "8:0 Read 30", "8:0 Total 30", "Total 60"},
+		[]string{"8:16 Read 1", "8:16 Write 2", "8:0 Read 3"},
+	)
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("parseBlkioStats failed...")
+	}
+	if stats[0].Minor != 0 || stats[0].ReadBytes != 30 || stats[1].WriteBytes != 20 || stats[1].WriteOps != 2 {
+		t.Errorf("parseBlkioStats failed: %+v", stats)
+	}
+}
+
+func TestParseMemoryStat(t *testing.T) {
+	v1 := parseMemoryStatV1(map[string]uint64{"cache": 1, "total_cache": 4096, "rss": 8192, "pgmajfault": 3})
+	if v1.Cache != 4096 || v1.File != 4096 || v1.RSS != 8192 || v1.PgMajFault != 3 {
+		t.Errorf("parseMemoryStatV1 failed: %+v", v1)
+	}
+
+	v2 := parseMemoryStatV2(map[string]uint64{"file": 4096, "anon": 8192, "file_mapped": 512})
+	if v2.Cache != 4096 || v2.RSS != 8192 || v2.MappedFile != 512 {
+		t.Errorf("parseMemoryStatV2 failed: %+v", v2)
+	}
+}
+
+func TestCgroupPath(t *testing.T) {
+	cgroups := "12:pids:/lxc/c1\n4:cpu,cpuacct:/lxc/c1\n1:name=systemd:/lxc/c1\n0::/lxc/c1\n"
+	if path, ok := procCgroupPath(cgroups, "cpuacct"); !ok || path != "/lxc/c1" {
+		t.Errorf("procCgroupPath failed: %q", path)
+	}
+	if path, ok := procCgroupPath(cgroups, ""); !ok || path != "/lxc/c1" {
+		t.Errorf("procCgroupPath failed: %q", path)
+	}
+	if _, ok := procCgroupPath(cgroups, "memory"); ok {
+		t.Errorf("procCgroupPath failed: found memory")
+	}
+
+	for _, tc := range []struct {
+		path     string
+		dir      string
+		expected string
+	}{
+		{"/lxc.payload.c1/init.scope", "", "/lxc.payload.c1"},
+		{"/lxc.payload.c1-2/system.slice/cron.service", "", "/lxc.payload.c1-2"},
+		{"/lxc/c1", "", "/lxc/c1"},
+		{"/lxc.payload.c10/init.scope", "", "/lxc.payload.c10/init.scope"},
+		{"/machine/c1/init.scope", "machine/c1", "/machine/c1"},
+		{"/user.slice/lxc.payload.c1", "", "/user.slice/lxc.payload.c1"},
+	} {
+		if path := payloadCgroup(tc.path, "c1", tc.dir); path != tc.expected {
+			t.Errorf("payloadCgroup(%s) failed: %q", tc.path, path)
+		}
+	}
+
+	mounts := "30 24 0:26 / /sys/fs/cgroup/memory rw,nosuid - cgroup cgroup rw,memory\n" +
+		"31 24 0:27 /lxc /sys/fs/cgroup/unified rw,nosuid - cgroup2 cgroup2 rw\n"
+	if mountPoint, root, ok := cgroupMountPoint(mounts, "memory"); !ok || mountPoint != "/sys/fs/cgroup/memory" || root != "/" {
+		t.Errorf("cgroupMountPoint failed: %q %q", mountPoint, root)
+	}
+	if mountPoint, root, ok := cgroupMountPoint(mounts, ""); !ok || mountPoint != "/sys/fs/cgroup/unified" || root != "/lxc" {
+		t.Errorf("cgroupMountPoint failed: %q %q", mountPoint, root)
+	}
+}
+
+func TestReadPressure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "memory.pressure")
+	os.WriteFile(path, []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=1500\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"), 0644)
+	if pressure, err := readPressure(path); err != nil || pressure.Some.Total != 1500*time.Microsecond {
+		t.Errorf("readPressure failed: %+v %v", pressure, err)
+	}
+}
+
+func TestParsePressure(t *testing.T) {
+	pressure, err := parsePressure([]string{
+		"some avg10=1.50 avg60=0.25 avg300=0.00 total=2000",
+		"full avg10=0.00 avg60=0.00 avg300=0.00 total=0",
+	})
+	if err != nil || pressure.Some.Avg10 != 1.5 || pressure.Some.Avg60 != 0.25 || pressure.Some.Total != 2*time.Millisecond {
+		t.Errorf("parsePressure failed: %+v %v", pressure, err)
+	}
+
+	if _, err := parsePressure([]string{"some avg10=x"}); err == nil {
+		t.Errorf("parsePressure accepted an invalid average...")
+	}
+}
+
+func TestParseNetDev(t *testing.T) {
+	data := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     100       2    0    0    0     0          0         0      100       2    0    0    0     0       0          0
+  eth0: 4096 10 1 2 0 0 0 0 2048 5 3 4 0 0 0 0
+`
+	stats, err := parseNetDev(data)
+	if err != nil || len(stats) != 2 {
+		t.Fatalf("parseNetDev failed: %v %v", stats, err)
+	}
+
+	expected := InterfaceStats{RxBytes: 4096, RxPackets: 10, RxErrors: 1, RxDropped: 2, TxBytes: 2048, TxPackets: 5, TxErrors: 3, TxDropped: 4}
+	if stats["eth0"] != expected {
+		t.Errorf("parseNetDev failed: %+v", stats["eth0"])
+	}
+}
+
+func TestCPUUsage(t *testing.T) {
+	now := time.Now()
+	prev := &cpuSample{time: now, perCPU: []time.Duration{0, 0}}
+	cur := &cpuSample{
+		time:             now.Add(time.Second),
+		total:            1500 * time.Millisecond,
+		perCPU:           []time.Duration{time.Second, 500 * time.Millisecond},
+		user:             100,
+		system:           50,
+		periods:          10,
+		throttledPeriods: 2,
+		cpus:             2,
+	}
+
+	usage := cpuUsage(prev, cur)
+	if usage.Percent != 150 || usage.NormalizedPercent != 75 || usage.User != 100 || usage.System != 50 {
+		t.Errorf("cpuUsage failed: %+v", usage)
+	}
+	if len(usage.PerCPU) != 2 || usage.PerCPU[0] != 100 || usage.PerCPU[1] != 50 {
+		t.Errorf("cpuUsage failed: %v", usage.PerCPU)
+	}
+	if usage.Periods != 10 || usage.ThrottledPeriods != 2 {
+		t.Errorf("cpuUsage failed: %+v", usage)
+	}
+
+	if cpuListSize("0-3,6") != 5 || cpuListSize("") != 0 {
+		t.Errorf("cpuListSize failed...")
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	p, err := parseProcStat("4242 (my (odd) cmd) S 1 4242 4242 0 -1 4194560 100 0 0 0 150 50 0 0 20 0 1 0 12345 1000000 25 18446744073709551615")
+	if err != nil {
+		t.Fatalf("parseProcStat failed: %s", err)
+	}
+	if p.PID != 4242 || p.PPID != 1 || p.Command != "my (odd) cmd" || p.State != "S" {
+		t.Errorf("parseProcStat failed: %+v", p)
+	}
+	if p.CPUTime != 2*time.Second || p.RSS != ByteSize(25*os.Getpagesize()) {
+		t.Errorf("parseProcStat failed: %s %s", p.CPUTime, p.RSS)
+	}
+}
+
+func TestContainerID(t *testing.T) {
+	mappings := parseIDMap("         0     100000      65536\n")
+	if containerID(mappings, 100000) != 0 || containerID(mappings, 101000) != 1000 || containerID(mappings, 0) != -1 {
+		t.Errorf("containerID failed...")
+	}
+
+	users := parsePasswd("root:x:0:0:root:/root:/bin/sh\n# comment\nubuntu:x:1000:1000::/home/ubuntu:/bin/sh\n")
+	if users[0] != "root" || users[1000] != "ubuntu" || len(users) != 2 {
+		t.Errorf("parsePasswd failed: %v", users)
+	}
+}
+
+func TestCgroupProcs(t *testing.T) {
+	top := t.TempDir()
+	os.MkdirAll(filepath.Join(top, "init.scope"), 0755)
+	os.MkdirAll(filepath.Join(top, "system.slice", "cron.service"), 0755)
+	os.WriteFile(filepath.Join(top, "cgroup.procs"), nil, 0644)
+	os.WriteFile(filepath.Join(top, "init.scope", "cgroup.procs"), []byte("1042\n"), 0644)
+	os.WriteFile(filepath.Join(top, "system.slice", "cron.service", "cgroup.procs"), []byte("1107\n1099\n"), 0644)
+
+	pids, err := cgroupProcs(top)
+	if err != nil || len(pids) != 3 || pids[0] != 1042 || pids[2] != 1107 {
+		t.Errorf("cgroupProcs failed: %v %v", pids, err)
+	}
+}
+
+func TestNamespaceString(t *testing.T) {
+	if (NAMESPACE_NET|NAMESPACE_UTS).String() != "uts|net" || Namespace(0).String() != "<INVALID>" {
+		t.Errorf("Namespace.String failed: %s", NAMESPACE_NET|NAMESPACE_UTS)
+	}
+}
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo("36 35 98:0 /mnt1 /mnt\\0402 rw,noatime master:1 shared:2 - ext3 /dev/root rw,errors=continue\n" +
+		"40 36 0:5 / /dev rw - devtmpfs udev rw\n")
+	if err != nil || len(mounts) != 2 {
+		t.Fatalf("parseMountInfo failed: %v", err)
+	}
+
+	m := mounts[0]
+	if m.ID != 36 || m.ParentID != 35 || m.Major != 98 || m.Minor != 0 || m.Root != "/mnt1" || m.MountPoint != "/mnt 2" {
+		t.Errorf("parseMountInfo failed: %+v", m)
+	}
+	if len(m.Propagation) != 2 || m.Propagation[0] != "master:1" || m.FSType != "ext3" || m.Source != "/dev/root" {
+		t.Errorf("parseMountInfo failed: %+v", m)
+	}
+	if len(m.Options) != 2 || m.Options[1] != "noatime" || len(m.SuperOptions) != 2 {
+		t.Errorf("parseMountInfo failed: %+v", m)
+	}
+	if len(mounts[1].Propagation) != 0 {
+		t.Errorf("parseMountInfo failed: %+v", mounts[1])
+	}
+
+	if _, err := parseMountInfo("36 35 98:0 / /mnt rw\n"); err == nil {
+		t.Errorf("parseMountInfo accepted a line without separator...")
+	}
+}
+
+func TestRootFS_Symlinks(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("rubik\n"), 0644)
+	os.Symlink("/etc/hostname", filepath.Join(root, "absolute"))
+	os.Symlink("../../../../etc/hostname", filepath.Join(root, "etc", "relative"))
+	os.Symlink("loop", filepath.Join(root, "loop"))
+	os.Symlink("/dev/null", filepath.Join(root, "device"))
+
+	fsys := &rootFS{layers: []string{root}}
+	for _, name := range []string{"absolute", "etc/relative"} {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || string(data) != "rubik\n" {
+			t.Errorf("ReadFile(%s) failed: %q %v", name, data, err)
+		}
+	}
+	if _, err := fs.ReadFile(fsys, "loop"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("ReadFile(loop) failed: %v", err)
+	}
+	// resolves to <root>/dev/null, which doesn't exist
+	if _, err := fs.ReadFile(fsys, "device"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile(device) escaped the root: %v", err)
+	}
+
+	os.Remove(filepath.Join(root, "loop"))
+	os.Remove(filepath.Join(root, "device"))
+	if err := fstest.TestFS(fsys, "etc/hostname", "absolute", "etc/relative"); err != nil {
+		t.Errorf("TestFS failed: %s", err)
+	}
+}
+
+func TestOpenInRoot(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("rubik\n"), 0644)
+	// a component swapped for a symlink after the path was resolved
+	os.Symlink("/etc", filepath.Join(root, "swapped"))
+
+	f, err := openInRoot(root, "etc/hostname", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("openInRoot failed: %s", err)
+	}
+	f.Close()
+	if _, err := openInRoot(root, "swapped/hostname", os.O_RDONLY); !errors.Is(err, syscall.ENOTDIR) {
+		t.Errorf("openInRoot followed a symlink: %v", err)
+	}
+	if _, err := openInRoot(root, "swapped", os.O_RDONLY); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("openInRoot followed a symlink: %v", err)
+	}
+
+	dir, err := os.Open(filepath.Join(root, "etc"))
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	defer dir.Close()
+	if _, err := lstatAt(dir, ".."); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("lstatAt accepted a parent entry: %v", err)
+	}
+}
+
+func TestRootFS_Overlay(t *testing.T) {
+	lower, upper := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(lower, "kept"), []byte("lower"), 0644)
+	os.WriteFile(filepath.Join(lower, "replaced"), []byte("lower"), 0644)
+	os.WriteFile(filepath.Join(lower, "deleted"), []byte("lower"), 0644)
+	os.WriteFile(filepath.Join(upper, "replaced"), []byte("upper"), 0644)
+	if err := syscall.Mknod(filepath.Join(upper, "deleted"), syscall.S_IFCHR, 0); err != nil {
+		t.Fatalf("Mknod failed: %s", err)
+	}
+
+	layers, err := rootFSLayers("overlay:" + lower + ":" + upper)
+	if err != nil || len(layers) != 2 || layers[0] != upper {
+		t.Fatalf("rootFSLayers failed: %v %v", layers, err)
+	}
+	fsys := &rootFS{layers: layers}
+
+	if data, err := fs.ReadFile(fsys, "replaced"); err != nil || string(data) != "upper" {
+		t.Errorf("ReadFile(replaced) failed: %q %v", data, err)
+	}
+	if _, err := fs.Stat(fsys, "deleted"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Stat(deleted) failed: %v", err)
+	}
+	if err := fstest.TestFS(fsys, "kept", "replaced"); err != nil {
+		t.Errorf("TestFS failed: %s", err)
+	}
+
+	if _, err := rootFSLayers("lvm:/dev/lxc/rubik"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("rootFSLayers accepted a block device...")
+	}
+}
+
+func TestIDMaps(t *testing.T) {
+	maps := parseConfigIDMap("u 0 100000 65536\ng 0 200000 65536")
+	if uid, gid, err := maps.toHost(1000, 1000); err != nil || uid != 101000 || gid != 201000 {
+		t.Errorf("toHost failed: %d %d %v", uid, gid, err)
+	}
+	if _, _, err := maps.toHost(70000, 0); err == nil {
+		t.Errorf("toHost mapped an unmapped ID...")
+	}
+	if uid, gid := maps.toContainer(100000, 0); uid != 0 || gid != overflowID {
+		t.Errorf("toContainer failed: %d %d", uid, gid)
+	}
+
+	// privileged containers
+	if uid, gid, err := (idMaps{}).toHost(1000, 1000); err != nil || uid != 1000 || gid != 1000 {
+		t.Errorf("toHost failed: %d %d %v", uid, gid, err)
+	}
+}
+
+func TestCopyInOut(t *testing.T) {
+	root := t.TempDir()
+	os.Symlink("/", filepath.Join(root, "escape"))
+	files := &containerFiles{root: &rootFS{layers: []string{root}}}
+
+	var b bytes.Buffer
+	tw := tar.NewWriter(&b)
+	tw.WriteHeader(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755})
+	tw.WriteHeader(&tar.Header{Name: "etc/motd", Typeflag: tar.TypeReg, Mode: 0600, Size: 5, Uid: 1000, ModTime: time.Unix(1000000000, 0)})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "motd"})
+	tw.WriteHeader(&tar.Header{Name: "../../escape/tmp/passwd", Typeflag: tar.TypeReg, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+
+	if err := files.copyIn(&b, "/srv"); err != nil {
+		t.Fatalf("copyIn failed: %s", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(root, "srv", "etc", "motd"))
+	if err != nil || fi.Mode().Perm() != 0600 || fi.Sys().(*syscall.Stat_t).Uid != 1000 || fi.ModTime().Unix() != 1000000000 {
+		t.Errorf("copyIn failed: %v %v", fi, err)
+	}
+	// the symlink is resolved inside the root
+	if _, err := os.Stat(filepath.Join(root, "tmp", "passwd")); err != nil {
+		t.Errorf("copyIn failed: %v", err)
+	}
+
+	// a component swapped for a symlink after resolving fails the write
+	if dir, err := files.copyUp("/escape/tmp"); err == nil {
+		dir.Close()
+		t.Errorf("copyUp followed a symlink...")
+	}
+
+	// device nodes are only created for templates
+	b.Reset()
+	tw = tar.NewWriter(&b)
+	tw.WriteHeader(&tar.Header{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0660, Devmajor: 8})
+	tw.Close()
+	if err := files.copyIn(bytes.NewReader(b.Bytes()), "/"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("copyIn created a block device: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(root, "dev", "sda")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("copyIn created a block device: %v", err)
+	}
+	devices := &containerFiles{root: files.root, devices: true}
+	if err := devices.copyIn(bytes.NewReader(b.Bytes()), "/"); err != nil {
+		t.Errorf("copyIn failed: %s", err)
+	}
+	if fi, err := os.Lstat(filepath.Join(root, "dev", "sda")); err != nil || fi.Mode()&fs.ModeDevice == 0 {
+		t.Errorf("copyIn failed: %v %v", fi, err)
+	}
+
+	var out bytes.Buffer
+	if err := files.writeTar(&out, "srv/etc"); err != nil {
+		t.Fatalf("writeTar failed: %s", err)
+	}
+	var names []string
+	tr := tar.NewReader(&out)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "./,link,motd" {
+		t.Errorf("writeTar failed: %v", names)
+	}
+}
+
+func TestCopyIn_Overlay(t *testing.T) {
+	lower, upper := t.TempDir(), t.TempDir()
+	os.MkdirAll(filepath.Join(lower, "etc", "default"), 0750)
+	files := &containerFiles{root: &rootFS{layers: []string{upper, lower}}}
+
+	if err := files.writeFile("/etc/default/motd", strings.NewReader("hello"), 0644, 0, 0, time.Time{}); err != nil {
+		t.Fatalf("writeFile failed: %s", err)
+	}
+	fi, err := os.Stat(filepath.Join(upper, "etc", "default"))
+	if err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("copyUp failed: %v %v", fi, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(upper, "etc", "default", "motd")); err != nil || string(data) != "hello" {
+		t.Errorf("writeFile failed: %q %v", data, err)
+	}
+	if _, err := os.Stat(filepath.Join(lower, "etc", "default", "motd")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("writeFile wrote to the lower layer: %v", err)
+	}
+
+	os.WriteFile(filepath.Join(lower, "etc", "issue"), []byte("lower"), 0640)
+	if err := files.link("/etc/issue.net", "/etc/issue"); err != nil {
+		t.Fatalf("link failed: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(upper, "etc", "issue.net"), []byte("upper"), 0640); err != nil {
+		t.Fatalf("WriteFile failed: %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(upper, "etc", "issue")); err != nil || string(data) != "upper" {
+		t.Errorf("link failed: %q %v", data, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(lower, "etc", "issue")); string(data) != "lower" {
+		t.Errorf("link wrote to the lower layer: %q", data)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "etc"), 0755)
+	os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte("rubik\n"), 0644)
+	os.Symlink("hostname", filepath.Join(root, "etc", "name"))
+	os.MkdirAll(filepath.Join(root, "var", "cache"), 0755)
+	os.WriteFile(filepath.Join(root, "var", "cache", "big"), []byte("x"), 0644)
+	if err := syscall.Mkfifo(filepath.Join(root, "etc", "initctl"), 0600); err != nil {
+		t.Fatalf("Mkfifo failed: %s", err)
+	}
+	files := &containerFiles{root: &rootFS{layers: []string{root}}}
+
+	config := "lxc.uts.name = rubik\n" +
+		"lxc.rootfs.path = overlay:/var/lib/lxc/base/rootfs:/var/lib/lxc/rubik/delta0\n" +
+		"lxc.mount.fstab = /var/lib/lxc/rubik/fstab\n" +
+		"lxc.hook.pre-start = /var/lib/lxc/rubikcube/hook\n"
+	export := func() []byte {
+		var b bytes.Buffer
+		manifest := &ExportManifest{Name: "rubik", Path: "/var/lib/lxc/rubik"}
+		if err := writeExport(&b, ExportOptions{Exclude: []string{"/var/cache"}}, files, []byte(config), manifest); err != nil {
+			t.Fatalf("writeExport failed: %s", err)
+		}
+		return b.Bytes()
+	}
+
+	dir := filepath.Join(t.TempDir(), "cube")
+	os.Mkdir(dir, 0750)
+	// the hook runs on the host
+	if _, err := readExport(bytes.NewReader(export()), dir, ImportOptions{}); !errors.Is(err, ErrHostConfig) {
+		t.Errorf("readExport accepted a hook: %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("readExport left %d entries behind", len(entries))
+	}
+
+	manifest, err := readExport(bytes.NewReader(export()), dir, ImportOptions{AllowHostConfig: true})
+	if err != nil {
+		t.Fatalf("readExport failed: %s", err)
+	}
+	if len(manifest.Checksums) != 2 {
+		t.Errorf("writeExport failed: %v", manifest.Checksums)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "rootfs", "etc", "hostname")); err != nil || string(data) != "rubik\n" {
+		t.Errorf("readExport failed: %q %v", data, err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "rootfs", "etc", "name")); err != nil || target != "hostname" {
+		t.Errorf("readExport failed: %q %v", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rootfs", "var", "cache")); err == nil {
+		t.Errorf("writeExport didn't exclude /var/cache...")
+	}
+	// fifos are recreated by mknod
+	if fi, err := os.Lstat(filepath.Join(dir, "rootfs", "etc", "initctl")); err != nil || fi.Mode()&fs.ModeNamedPipe == 0 {
+		t.Errorf("readExport failed: %v %v", fi, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, importStaging)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("readExport left the staging directory behind: %v", err)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(dir, "config"))
+	expected := "lxc.uts.name = cube\n" +
+		"lxc.rootfs.path = " + filepath.Join(dir, "rootfs") + "\n" +
+		"lxc.mount.fstab = " + filepath.Join(dir, "fstab") + "\n" +
+		"lxc.hook.pre-start = /var/lib/lxc/rubikcube/hook\n"
+	if string(data) != expected {
+		t.Errorf("rewriteConfig failed: %q", data)
+	}
+
+	// a file modified after the archive was written
+	var tampered bytes.Buffer
+	gz, _ := gzip.NewReader(bytes.NewReader(export()))
+	tr := tar.NewReader(gz)
+	gzw := gzip.NewWriter(&tampered)
+	tw := tar.NewWriter(gzw)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		content, _ := io.ReadAll(tr)
+		if hdr.Name == "rootfs/etc/hostname" {
+			content = []byte("cube!\n")
+		}
+		tw.WriteHeader(hdr)
+		tw.Write(content)
+	}
+	tw.Close()
+	gzw.Close()
+
+	dir = filepath.Join(t.TempDir(), "cube")
+	os.Mkdir(dir, 0750)
+	if _, err := readExport(&tampered, dir, ImportOptions{AllowHostConfig: true}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("readExport accepted a tampered archive: %v", err)
+	}
+	// nothing of the unverified archive is in place
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("readExport left %d entries behind", len(entries))
+	}
+}
+
+func TestHostConfigItem(t *testing.T) {
+	dir := t.TempDir()
+	staging := filepath.Join(dir, importStaging)
+	os.MkdirAll(filepath.Join(staging, "srv", "data"), 0755)
+	os.Symlink("/", filepath.Join(staging, "escape"))
+	rootfs := filepath.Join(dir, "rootfs")
+
+	for _, tc := range []struct {
+		key   string
+		value string
+		host  bool
+	}{
+		{"lxc.hook.pre-start", "/bin/true", true},
+		{"lxc.hook.version", "1", false},
+		{"lxc.net.0.script.up", "/bin/true", true},
+		{"lxc.net.1.script.down", "/bin/true", true},
+		{"lxc.net.0.type", "none", true},
+		{"lxc.net.0.type", "veth", false},
+		{"lxc.include", "/usr/share/lxc/config/common.conf", true},
+		{"lxc.log.file", "/etc/shadow", true},
+		{"lxc.console.logfile", "/etc/shadow", true},
+		{"lxc.console.path", "none", false},
+		{"lxc.apparmor.profile", "unconfined", true},
+		{"lxc.apparmor.profile", "generated", false},
+		{"lxc.mount.entry", "/home home none bind,create=dir 0 0", true},
+		{"lxc.mount.entry", rootfs + "/srv/data srv none bind 0 0", false},
+		{"lxc.mount.entry", rootfs + "/escape/etc srv none bind 0 0", true},
+		{"lxc.mount.entry", rootfs + "/missing srv none bind,optional 0 0", true},
+		{"lxc.mount.entry", dir + "2/data srv none bind 0 0", true},
+		{"lxc.mount.entry", "/dev/sda1 mnt ext4 defaults 0 0", true},
+		{"lxc.mount.entry", "tmpfs tmp tmpfs defaults 0 0", false},
+		{"lxc.mount.entry", "proc proc proc defaults 0 0", true},
+		{"lxc.mount.entry", "proc proc proc ro 0 0", false},
+		{"lxc.mount.fstab", dir + "/fstab", false},
+		{"lxc.mount.fstab", rootfs + "/etc/fstab", true},
+		{"lxc.mount.fstab", "/etc/fstab", true},
+		{"lxc.mount.auto", "proc:mixed sys:ro cgroup:mixed", false},
+		{"lxc.mount.auto", "proc:rw", true},
+		{"lxc.mount.auto", "sys:rw", true},
+		{"lxc.mount.auto", "cgroup:rw", true},
+		{"lxc.cgroup.devices.allow", "a", true},
+		{"lxc.cgroup2.devices.allow", "a", true},
+		{"lxc.cgroup.devices.allow", "c *:* m", false},
+		{"lxc.cgroup.devices.allow", "b 8:0 rwm", true},
+		{"lxc.cgroup.devices.allow", "c 1:3 rwm", false},
+	} {
+		if hostConfigItem(tc.key, tc.value, dir, staging) != tc.host {
+			t.Errorf("hostConfigItem(%s = %s) failed", tc.key, tc.value)
+		}
+	}
+}
+
+func TestTarballTemplate(t *testing.T) {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./etc/hostname", Mode: 0644, Size: 6, Uid: 1000, Gid: 1000})
+	tw.Write([]byte("rubik\n"))
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "./etc/name", Linkname: "hostname", Mode: 0777})
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeFifo, Name: "./run/initctl", Mode: 0600})
+	tw.Close()
+	gz.Close()
+	tarball := filepath.Join(t.TempDir(), "rootfs.tar.gz")
+	os.WriteFile(tarball, b.Bytes(), 0644)
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	ids := parseConfigIDMap("u 0 100000 65536\ng 0 100000 65536")
+	if err := populate(&TarballTemplate{Path: tarball}, rootfs, ids, false); err != nil {
+		t.Fatalf("populate failed: %s", err)
+	}
+	fi, err := os.Stat(filepath.Join(rootfs, "etc", "hostname"))
+	if err != nil {
+		t.Fatalf("populate failed: %s", err)
+	}
+	if st := fi.Sys().(*syscall.Stat_t); st.Uid != 101000 || st.Gid != 101000 {
+		t.Errorf("populate didn't map the owners: %d:%d", st.Uid, st.Gid)
+	}
+	if target, err := os.Readlink(filepath.Join(rootfs, "etc", "name")); err != nil || target != "hostname" {
+		t.Errorf("populate failed: %q %v", target, err)
+	}
+	if fi, err := os.Lstat(filepath.Join(rootfs, "run", "initctl")); err != nil || fi.Mode()&fs.ModeNamedPipe == 0 {
+		t.Errorf("populate didn't create the fifo: %v", err)
+	}
+
+	os.WriteFile(tarball, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, 0644)
+	if err := populate(&TarballTemplate{Path: tarball}, filepath.Join(t.TempDir(), "rootfs"), idMaps{}, false); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("populate accepted an xz tarball: %v", err)
+	}
+}
+
+func TestDirectoryTemplate(t *testing.T) {
+	src := t.TempDir()
+	os.MkdirAll(filepath.Join(src, "etc"), 0755)
+	os.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("rubik\n"), 0600)
+	os.Symlink("/etc/hostname", filepath.Join(src, "etc", "name"))
+
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := populate(&DirectoryTemplate{Path: src}, rootfs, idMaps{}, false); err != nil {
+		t.Fatalf("populate failed: %s", err)
+	}
+	if fi, err := os.Stat(filepath.Join(rootfs, "etc", "hostname")); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("populate failed: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(rootfs, "etc", "name")); err != nil || target != "/etc/hostname" {
+		t.Errorf("populate failed: %q %v", target, err)
+	}
+
+	var tx ConfigTx
+	(&DirectoryTemplate{Path: src, Arch: "amd64"}).Configure(&tx)
+	if len(tx.ops) != 1 || tx.ops[0].key != "lxc.arch" {
+		t.Errorf("Configure failed: %+v", tx.ops)
+	}
+}
+
+func TestBusyboxTemplate(t *testing.T) {
+	libraries, err := sharedLibraries("/bin/sh")
+	if err != nil {
+		t.Fatalf("sharedLibraries failed: %s", err)
+	}
+	for _, library := range libraries {
+		if _, err := os.Stat(library); err != nil {
+			t.Errorf("sharedLibraries failed: %s", err)
+		}
+	}
+
+	busybox, err := exec.LookPath("busybox")
+	if err != nil {
+		t.Skip("busybox is not installed")
+	}
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := populate(&BusyboxTemplate{Path: busybox}, rootfs, idMaps{}, false); err != nil {
+		t.Fatalf("populate failed: %s", err)
+	}
+	for _, name := range []string{"bin/busybox", "bin/sh", "sbin/init", "etc/inittab"} {
+		if _, err := os.Stat(filepath.Join(rootfs, name)); err != nil {
+			t.Errorf("populate failed: %s", err)
+		}
+	}
+}
+
+func TestSetConfigFileItems(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "default.conf")
+	os.WriteFile(name, []byte("# defaults\nlxc.net.0.type = veth\nlxc.net.0.link = lxcbr0\n"), 0644)
+
+	tx := &ConfigTx{}
+	if err := setConfigFileItems(tx, name); err != nil {
+		t.Fatalf("setConfigFileItems failed: %s", err)
+	}
+	if len(tx.ops) != 2 || tx.ops[0].key != "lxc.net.0.type" || tx.ops[1].value != "lxcbr0" {
+		t.Errorf("setConfigFileItems failed: %+v", tx.ops)
+	}
+	if err := setConfigFileItems(tx, name+".missing"); err != nil || len(tx.ops) != 2 {
+		t.Errorf("setConfigFileItems failed for a missing file: %v", err)
+	}
+}
+
+func TestImageStore(t *testing.T) {
+	store, err := NewImageStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewImageStore failed: %s", err)
+	}
+	tarball := func(hostname string) []byte {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gz)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "etc/hostname", Mode: 0644, Size: int64(len(hostname))})
+		tw.Write([]byte(hostname))
+		tw.Close()
+		gz.Close()
+		return b.Bytes()
+	}
+
+	old, err := store.Add(bytes.NewReader(tarball("rubik\n")), ImageMetadata{Distro: "ubuntu", Release: "quantal", Arch: "amd64", Created: time.Now().Add(-time.Hour)})
+	if err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	again, err := store.Add(bytes.NewReader(tarball("rubik\n")), ImageMetadata{Distro: "ubuntu"})
+	if err != nil || again.ID != old.ID || again.Release != "quantal" {
+		t.Errorf("Add stored the same tarball twice: %+v %v", again, err)
+	}
+	image, err := store.Add(bytes.NewReader(tarball("cube\n")), ImageMetadata{Distro: "ubuntu", Release: "quantal", Arch: "amd64"})
+	if err != nil {
+		t.Fatalf("Add failed: %s", err)
+	}
+	if _, err := store.Add(strings.NewReader("not a tarball"), ImageMetadata{}); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("Add accepted an invalid tarball: %v", err)
+	}
+
+	images, err := store.List()
+	if err != nil || len(images) != 2 || images[1].ID != image.ID {
+		t.Errorf("List failed: %+v %v", images, err)
+	}
+	if found, err := store.Find("ubuntu", "quantal", ""); err != nil || found.ID != image.ID {
+		t.Errorf("Find failed: %+v %v", found, err)
+	}
+	if _, err := store.Find("fedora", "", ""); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("Find failed: %v", err)
+	}
+	if found, err := store.Get("sha256:" + image.ID[:12]); err != nil || found.ID != image.ID {
+		t.Errorf("Get failed: %+v %v", found, err)
+	}
+
+	tmpl, err := store.Template(image.ID)
+	if err != nil {
+		t.Fatalf("Template failed: %s", err)
+	}
+	rootfs := filepath.Join(t.TempDir(), "rootfs")
+	if err := populate(tmpl, rootfs, idMaps{}, false); err != nil {
+		t.Fatalf("populate failed: %s", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(rootfs, "etc", "hostname")); err != nil || string(data) != "cube\n" {
+		t.Errorf("populate failed: %q %v", data, err)
+	}
+	if err := store.touch(image.ID); err != nil {
+		t.Errorf("touch failed: %s", err)
+	}
+
+	// a tarball modified after it was added
+	os.WriteFile(filepath.Join(store.dir, old.ID, imageRootFS), tarball("cube!\n"), 0600)
+	tmpl, _ = store.Template(old.ID)
+	if err := populate(tmpl, filepath.Join(t.TempDir(), "rootfs"), idMaps{}, false); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("populate accepted a modified image: %v", err)
+	}
+
+	removed, err := store.Prune(-time.Minute)
+	if err != nil || len(removed) != 2 {
+		t.Errorf("Prune failed: %+v %v", removed, err)
+	}
+	image, _ = store.Add(bytes.NewReader(tarball("rubik\n")), ImageMetadata{})
+	if removed, err := store.Prune(time.Hour); err != nil || len(removed) != 0 {
+		t.Errorf("Prune removed a new image: %+v %v", removed, err)
+	}
+	if err := store.Remove(image.ID); err != nil {
+		t.Errorf("Remove failed: %s", err)
+	}
+	if err := store.Remove(image.ID); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("Remove failed: %v", err)
+	}
+}
+
 func TestDefaultConfigPath(t *testing.T) {
 	if DefaultConfigPath() != CONFIG_FILE_PATH {
 		t.Errorf("DefaultConfigPath failed...")
@@ -105,6 +1065,27 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestCreateFromTemplate(t *testing.T) {
+	z := NewContainer("template")
+	defer PutContainer(z)
+
+	if err := z.CreateFromTemplate(&BusyboxTemplate{}, TemplateOptions{}); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			t.Skip("busybox is not installed")
+		}
+		t.Fatalf("CreateFromTemplate failed: %s", err)
+	}
+	if !z.Defined() {
+		t.Errorf("CreateFromTemplate failed...")
+	}
+	if err := z.CreateFromTemplate(&BusyboxTemplate{}, TemplateOptions{}); !errors.Is(err, ErrAlreadyDefined) {
+		t.Errorf("CreateFromTemplate recreated the container: %v", err)
+	}
+	if !z.Destroy() {
+		t.Errorf("Destroying the container failed...")
+	}
+}
+
 func TestConcurrentCreate(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -293,6 +1274,30 @@ func TestConfigItem(t *testing.T) {
 	}
 }
 
+func TestConfigValue(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	value, ok, err := z.ConfigValue("lxc.utsname")
+	if err != nil || !ok || value != CONTAINER_NAME {
+		t.Errorf("ConfigValue failed...")
+	}
+
+	if _, _, err := z.ConfigValue("lxc.nonexistent"); err == nil {
+		t.Errorf("ConfigValue failed...")
+	}
+}
+
+func TestConfigList(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	caps, ok, err := z.ConfigList("lxc.cap.drop")
+	if err != nil || (ok && len(caps) == 0) {
+		t.Errorf("ConfigList failed...")
+	}
+}
+
 func TestSetConfigItem(t *testing.T) {
 	z := NewContainer(CONTAINER_NAME)
 	defer PutContainer(z)
@@ -303,6 +1308,64 @@ func TestSetConfigItem(t *testing.T) {
 	}
 }
 
+func TestEditConfig(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	err := z.EditConfig(func(tx *ConfigTx) error {
+		tx.Set("lxc.utsname", "cube")
+		tx.Set("lxc.nonexistent", "value")
+		return nil
+	})
+	if err == nil || z.ConfigItem("lxc.utsname")[0] != CONTAINER_NAME {
+		t.Errorf("EditConfig failed to roll back...")
+	}
+
+	err = z.EditConfig(func(tx *ConfigTx) error {
+		tx.Set("lxc.utsname", CONTAINER_NAME)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("EditConfig failed...")
+	}
+}
+
+func TestSensitiveMountSource(t *testing.T) {
+	sources := map[string]AuditSeverity{
+		"/":                     AUDIT_CRITICAL,
+		"/etc/shadow":           AUDIT_HIGH,
+		"/root/.ssh":            AUDIT_HIGH,
+		"/proc/sys/kernel":      AUDIT_HIGH,
+		"/var/run/docker.sock/": AUDIT_CRITICAL,
+		"/home/rubik":           AUDIT_MEDIUM,
+	}
+	for source, expected := range sources {
+		if severity, ok := sensitiveMountSource(source); !ok || severity != expected {
+			t.Errorf("sensitiveMountSource(%q) failed: %s", source, severity)
+		}
+	}
+	if _, ok := sensitiveMountSource("/srv/data"); ok {
+		t.Errorf("sensitiveMountSource flagged /srv/data...")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	report, err := Audit(z)
+	if err != nil {
+		t.Fatalf("Audit failed: %s", err)
+	}
+	if report.Score < 0 || report.Score > 100 {
+		t.Errorf("Audit failed: score %d", report.Score)
+	}
+	if _, err := report.JSON(); err != nil {
+		t.Errorf("Audit failed: %s", err)
+	}
+	t.Logf("%s", report)
+}
+
 func TestSetCgroupItem(t *testing.T) {
 	z := NewContainer(CONTAINER_NAME)
 	defer PutContainer(z)
@@ -366,6 +1429,245 @@ func TestMemoryUsageInBytes(t *testing.T) {
 
 }
 
+func TestCPUTime(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	cpuTime, err := z.CPUTime()
+	if err != nil || cpuTime <= 0 {
+		t.Errorf("CPUTime failed...")
+	}
+
+	cpuStats, err := z.CPUStats()
+	if err != nil || len(cpuStats) != 2 {
+		t.Errorf("CPUStats failed...")
+	}
+}
+
+func TestSetMemoryLimit(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	var limitErr *InvalidLimitError
+	if err := z.SetMemoryLimit(0); !errors.As(err, &limitErr) {
+		t.Errorf("SetMemoryLimit accepted an invalid limit...")
+	}
+
+	if err := z.SetMemoryLimit(512 * MB); err != nil {
+		t.Errorf("SetMemoryLimit failed: %s", err)
+	}
+	if limit, _ := z.MemoryLimitInBytes(); limit != 512*MB {
+		t.Errorf("SetMemoryLimit failed...")
+	}
+}
+
+func TestSetCPUQuota(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	var limitErr *InvalidLimitError
+	if err := z.SetCPUQuota(time.Hour, time.Second); !errors.As(err, &limitErr) {
+		t.Errorf("SetCPUQuota accepted an invalid period...")
+	}
+
+	if err := z.SetCPUQuota(100*time.Millisecond, 50*time.Millisecond); err != nil {
+		t.Errorf("SetCPUQuota failed: %s", err)
+	}
+}
+
+func TestBlockIOStats(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	if _, err := z.BlockIOStats(); err != nil {
+		t.Errorf("BlockIOStats failed: %s", err)
+	}
+
+	if err := z.SetBlockIOLimit("/dev/null", 0, 0, 0, 0); err == nil {
+		t.Errorf("SetBlockIOLimit accepted a character device...")
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	stats, err := z.MemoryStats()
+	if err != nil {
+		t.Fatalf("MemoryStats failed: %s", err)
+	}
+	t.Logf("Cache: %s RSS: %s OOM kills: %d\n", stats.Cache, stats.RSS, stats.OOMKills)
+}
+
+func TestNotifyOOM(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	events, err := z.NotifyOOM(ctx)
+	if err != nil {
+		t.Fatalf("NotifyOOM failed: %s", err)
+	}
+	for event := range events {
+		t.Errorf("NotifyOOM failed: unexpected OOM event %+v", event)
+	}
+}
+
+func TestPressure(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	if _, err := z.Pressure(); err != nil && !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Pressure failed: %s", err)
+	}
+
+	if _, err := z.NotifyPressure(context.Background(), PRESSURE_MEMORY, PRESSURE_SOME, time.Second, time.Minute); err == nil {
+		t.Errorf("NotifyPressure accepted a window longer than 10s...")
+	}
+}
+
+func TestNetworkStats(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	stats, err := z.NetworkStats()
+	if err != nil || len(stats) == 0 {
+		t.Errorf("NetworkStats failed: %v", err)
+	}
+}
+
+func TestCPUSampler(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	sampler, err := NewCPUSampler(z)
+	if err != nil {
+		t.Fatalf("NewCPUSampler failed: %s", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	usage, err := sampler.Sample()
+	if err != nil || usage.CPUs <= 0 || usage.Percent < 0 {
+		t.Errorf("Sample failed: %+v %v", usage, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	stats, err := z.Stats()
+	if err != nil || stats.MemoryUsage <= 0 || stats.CPUTime <= 0 {
+		t.Errorf("Stats failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := z.StreamStats(ctx, 0); !errors.Is(err, ErrInvalidInterval) {
+		t.Errorf("StreamStats accepted a zero interval: %v", err)
+	}
+	ch, err := z.StreamStats(ctx, 100*time.Millisecond)
+	if err != nil {
+		t.Fatalf("StreamStats failed: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, ok := <-ch; !ok {
+			t.Errorf("StreamStats failed...")
+		}
+	}
+	cancel()
+	for range ch {
+	}
+}
+
+func TestProcesses(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	processes, err := z.Processes()
+	if err != nil || len(processes) == 0 {
+		t.Fatalf("Processes failed: %v", err)
+	}
+	if processes[0].PID != z.InitPID() || processes[0].NSPID != 1 {
+		t.Errorf("Processes failed: %+v", processes[0])
+	}
+}
+
+func TestRunInNamespaces(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	var hostname string
+	err := z.RunInNamespaces(NAMESPACE_UTS, func() error {
+		var err error
+		hostname, err = os.Hostname()
+		return err
+	})
+	if err != nil || hostname != CONTAINER_NAME {
+		t.Errorf("RunInNamespaces failed: %q %v", hostname, err)
+	}
+
+	if err := z.RunInNamespaces(NAMESPACE_USER, func() error { return nil }); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("RunInNamespaces joined a user namespace...")
+	}
+}
+
+func TestMounts(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	mounts, err := z.Mounts()
+	if err != nil || len(mounts) == 0 {
+		t.Fatalf("Mounts failed: %v", err)
+	}
+	if mounts[0].MountPoint != "/" {
+		t.Errorf("Mounts failed: %+v", mounts[0])
+	}
+}
+
+func TestRootFS(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	fsys, err := z.RootFS()
+	if err != nil {
+		t.Fatalf("RootFS failed: %s", err)
+	}
+	if _, err := fs.ReadFile(fsys, "etc/hostname"); err != nil {
+		t.Errorf("ReadFile failed: %s", err)
+	}
+}
+
+func TestPushPullFile(t *testing.T) {
+	z := NewContainer(CONTAINER_NAME)
+	defer PutContainer(z)
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "motd")
+	os.WriteFile(src, []byte("hello\n"), 0644)
+
+	if err := z.PushFile(src, "/tmp/motd", 0600, 0, 0); err != nil {
+		t.Fatalf("PushFile failed: %s", err)
+	}
+	dst := filepath.Join(dir, "pulled")
+	if err := z.PullFile("/tmp/motd", dst); err != nil {
+		t.Fatalf("PullFile failed: %s", err)
+	}
+	if data, err := os.ReadFile(dst); err != nil || string(data) != "hello\n" {
+		t.Errorf("PullFile failed: %q %v", data, err)
+	}
+
+	r, err := z.CopyOut("/etc")
+	if err != nil {
+		t.Fatalf("CopyOut failed: %s", err)
+	}
+	defer r.Close()
+	if _, err := tar.NewReader(r).Next(); err != nil {
+		t.Errorf("CopyOut failed: %s", err)
+	}
+}
+
 func TestConcurrentShutdown(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -0,0 +1,133 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+// Breakdown of the memory used by a container. Raw holds every value of
+// memory.stat under its original name.
+type MemoryStats struct {
+	Cache        ByteSize
+	RSS          ByteSize
+	RSSHuge      ByteSize
+	MappedFile   ByteSize
+	Shmem        ByteSize
+	Anon         ByteSize
+	File         ByteSize
+	Dirty        ByteSize
+	Writeback    ByteSize
+	ActiveAnon   ByteSize
+	InactiveAnon ByteSize
+	ActiveFile   ByteSize
+	InactiveFile ByteSize
+	Unevictable  ByteSize
+
+	PgFault    uint64
+	PgMajFault uint64
+
+	// number of times the OOM killer was invoked, cgroup v2 only
+	OOMEvents uint64
+	// number of processes killed by the OOM killer
+	OOMKills uint64
+	// whether the cgroup is currently under OOM, cgroup v1 only
+	UnderOOM bool
+
+	Raw map[string]uint64
+}
+
+// Returns the memory.stat breakdown and the OOM counters of the container
+func (lxc *Container) MemoryStats() (*MemoryStats, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+	return lxc.memoryStats()
+}
+
+func (lxc *Container) memoryStats() (*MemoryStats, error) {
+	raw, err := lxc.cgroupStats("memory.stat")
+	if err != nil {
+		return nil, err
+	}
+
+	var stats *MemoryStats
+	if unifiedCgroup() {
+		stats = parseMemoryStatV2(raw)
+		events, err := lxc.cgroupStats("memory.events")
+		if err != nil {
+			return nil, err
+		}
+		stats.OOMEvents = events["oom"]
+		stats.OOMKills = events["oom_kill"]
+	} else {
+		stats = parseMemoryStatV1(raw)
+		control, err := lxc.cgroupStats("memory.oom_control")
+		if err != nil {
+			return nil, err
+		}
+		stats.OOMKills = control["oom_kill"]
+		stats.UnderOOM = control["under_oom"] == 1
+	}
+	return stats, nil
+}
+
+// cgroup v1 reports the values of the cgroup itself and, prefixed with total_,
+// including its children. The latter are preferred when available.
+func parseMemoryStatV1(raw map[string]uint64) *MemoryStats {
+	value := func(key string) uint64 {
+		if v, ok := raw["total_"+key]; ok {
+			return v
+		}
+		return raw[key]
+	}
+
+	return &MemoryStats{
+		Cache:        ByteSize(value("cache")),
+		RSS:          ByteSize(value("rss")),
+		RSSHuge:      ByteSize(value("rss_huge")),
+		MappedFile:   ByteSize(value("mapped_file")),
+		Shmem:        ByteSize(value("shmem")),
+		Anon:         ByteSize(value("rss")),
+		File:         ByteSize(value("cache")),
+		Dirty:        ByteSize(value("dirty")),
+		Writeback:    ByteSize(value("writeback")),
+		ActiveAnon:   ByteSize(value("active_anon")),
+		InactiveAnon: ByteSize(value("inactive_anon")),
+		ActiveFile:   ByteSize(value("active_file")),
+		InactiveFile: ByteSize(value("inactive_file")),
+		Unevictable:  ByteSize(value("unevictable")),
+		PgFault:      value("pgfault"),
+		PgMajFault:   value("pgmajfault"),
+		Raw:          raw,
+	}
+}
+
+// cgroup v2 names the values differently, they are mapped to their v1
+// counterparts as well
+func parseMemoryStatV2(raw map[string]uint64) *MemoryStats {
+	return &MemoryStats{
+		Cache:        ByteSize(raw["file"]),
+		RSS:          ByteSize(raw["anon"]),
+		RSSHuge:      ByteSize(raw["anon_thp"]),
+		MappedFile:   ByteSize(raw["file_mapped"]),
+		Shmem:        ByteSize(raw["shmem"]),
+		Anon:         ByteSize(raw["anon"]),
+		File:         ByteSize(raw["file"]),
+		Dirty:        ByteSize(raw["file_dirty"]),
+		Writeback:    ByteSize(raw["file_writeback"]),
+		ActiveAnon:   ByteSize(raw["active_anon"]),
+		InactiveAnon: ByteSize(raw["inactive_anon"]),
+		ActiveFile:   ByteSize(raw["active_file"]),
+		InactiveFile: ByteSize(raw["inactive_file"]),
+		Unevictable:  ByteSize(raw["unevictable"]),
+		PgFault:      raw["pgfault"],
+		PgMajFault:   raw["pgmajfault"],
+		Raw:          raw,
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A mount visible in a mount namespace, as described in proc(5)
+type Mount struct {
+	ID       int
+	ParentID int
+	Major    uint32
+	Minor    uint32
+	// directory of the filesystem forming the root of the mount
+	Root       string
+	MountPoint string
+	Options    []string
+	// optional fields like shared:1, master:2 or unbindable
+	Propagation  []string
+	FSType       string
+	Source       string
+	SuperOptions []string
+}
+
+// Returns the mounts in the container's mount namespace
+func (lxc *Container) Mounts() ([]Mount, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/mountinfo", lxc.initPID()))
+	if err != nil {
+		return nil, err
+	}
+	return parseMountInfo(string(data))
+}
+
+// Parses mountinfo lines like
+// "36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue"
+func parseMountInfo(data string) ([]Mount, error) {
+	var mounts []Mount
+
+	for _, line := range strings.Split(data, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+		if separator == -1 || len(fields) < separator+3 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", line)
+		}
+
+		m := Mount{
+			Root:        unescapeMountField(fields[3]),
+			MountPoint:  unescapeMountField(fields[4]),
+			Options:     strings.Split(fields[5], ","),
+			Propagation: fields[6:separator],
+			FSType:      fields[separator+1],
+			Source:      unescapeMountField(fields[separator+2]),
+		}
+		if len(fields) > separator+3 {
+			m.SuperOptions = strings.Split(fields[separator+3], ",")
+		}
+
+		var err error
+		if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid mountinfo line %q: %w", line, err)
+		}
+		if m.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid mountinfo line %q: %w", line, err)
+		}
+		if err := parseDeviceNumber(fields[2], &m.Major, &m.Minor); err != nil {
+			return nil, fmt.Errorf("invalid mountinfo line %q: %w", line, err)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// The kernel escapes spaces, tabs, newlines and backslashes in octal, like
+// \040 for a space
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,189 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+// #define _GNU_SOURCE
+// #include <sched.h>
+import "C"
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// Namespaces of a container, combined with |
+type Namespace int
+
+const (
+	NAMESPACE_MNT  Namespace = syscall.CLONE_NEWNS
+	NAMESPACE_UTS  Namespace = syscall.CLONE_NEWUTS
+	NAMESPACE_IPC  Namespace = syscall.CLONE_NEWIPC
+	NAMESPACE_USER Namespace = syscall.CLONE_NEWUSER
+	NAMESPACE_PID  Namespace = syscall.CLONE_NEWPID
+	NAMESPACE_NET  Namespace = syscall.CLONE_NEWNET
+)
+
+// the order namespaces are joined in, the same as nsenter(1)
+var namespaceFiles = []struct {
+	namespace Namespace
+	name      string
+}{
+	{NAMESPACE_USER, "user"},
+	{NAMESPACE_IPC, "ipc"},
+	{NAMESPACE_UTS, "uts"},
+	{NAMESPACE_NET, "net"},
+	{NAMESPACE_PID, "pid"},
+	{NAMESPACE_MNT, "mnt"},
+}
+
+// Namespace as string, like "net|uts"
+func (ns Namespace) String() string {
+	var names []string
+	for _, f := range namespaceFiles {
+		if ns&f.namespace != 0 {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "<INVALID>"
+	}
+	return strings.Join(names, "|")
+}
+
+func allNamespaces() Namespace {
+	var all Namespace
+	for _, f := range namespaceFiles {
+		all |= f.namespace
+	}
+	return all
+}
+
+type namespaceFile struct {
+	namespace Namespace
+	file      *os.File
+}
+
+// Runs fn on a dedicated OS thread which joined the given namespaces of the
+// container's init process.
+//
+// Joining NAMESPACE_PID only affects processes started by fn. Threads joining
+// NAMESPACE_MNT can't be restored and are terminated once fn returns. The
+// kernel doesn't let multithreaded processes, like all Go programs, join
+// NAMESPACE_USER.
+func (lxc *Container) RunInNamespaces(namespaces Namespace, fn func() error) error {
+	if namespaces&NAMESPACE_USER != 0 {
+		return fmt.Errorf("%w: user namespaces can't be joined by multithreaded processes", ErrNotSupported)
+	}
+	if namespaces == 0 || namespaces&^allNamespaces() != 0 {
+		return fmt.Errorf("%w: namespaces %#x", ErrNotSupported, int(namespaces))
+	}
+
+	// opened under the lock so that all belong to the same init process
+	lxc.mu.RLock()
+	if !lxc.running() {
+		lxc.mu.RUnlock()
+		return ErrNotRunning
+	}
+	targets, err := openNamespaces(fmt.Sprintf("/proc/%d/ns", lxc.initPID()), namespaces)
+	lxc.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	defer closeNamespaces(targets)
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		restored, err := runInNamespaces(targets, namespaces, fn)
+		if restored {
+			runtime.UnlockOSThread()
+		}
+		// otherwise the thread is terminated along with the goroutine
+		errc <- err
+	}()
+	return <-errc
+}
+
+func openNamespaces(dir string, namespaces Namespace) ([]namespaceFile, error) {
+	var files []namespaceFile
+	for _, f := range namespaceFiles {
+		if namespaces&f.namespace == 0 {
+			continue
+		}
+		file, err := os.Open(dir + "/" + f.name)
+		if err != nil {
+			closeNamespaces(files)
+			return nil, err
+		}
+		files = append(files, namespaceFile{namespace: f.namespace, file: file})
+	}
+	return files, nil
+}
+
+func closeNamespaces(files []namespaceFile) {
+	for _, f := range files {
+		f.file.Close()
+	}
+}
+
+func setns(f *os.File, namespace Namespace) error {
+	if ret, err := C.setns(C.int(f.Fd()), C.int(namespace)); ret != 0 {
+		return &os.SyscallError{Syscall: fmt.Sprintf("setns %s", namespace), Err: err}
+	}
+	return nil
+}
+
+// Joins the namespaces on the current, locked, thread, runs fn and returns to
+// the original namespaces. Returns whether the thread could be restored.
+func runInNamespaces(targets []namespaceFile, namespaces Namespace, fn func() error) (bool, error) {
+	// the mount namespace is never left, so the original isn't needed
+	originals, err := openNamespaces(fmt.Sprintf("/proc/self/task/%d/ns", syscall.Gettid()), namespaces&^NAMESPACE_MNT)
+	if err != nil {
+		return true, err
+	}
+	defer closeNamespaces(originals)
+
+	restorable := true
+	if namespaces&NAMESPACE_MNT != 0 {
+		// setns refuses to change the mount namespace of a thread sharing
+		// its root and working directory with others
+		if err := syscall.Unshare(syscall.CLONE_FS); err != nil {
+			return true, os.NewSyscallError("unshare", err)
+		}
+		restorable = false
+	}
+
+	// returns to the original namespaces of the joined ones
+	restore := func(joined int) bool {
+		ok := true
+		for _, original := range originals {
+			for _, target := range targets[:joined] {
+				if target.namespace == original.namespace && setns(original.file, original.namespace) != nil {
+					ok = false
+				}
+			}
+		}
+		return ok
+	}
+
+	for i, target := range targets {
+		if err := setns(target.file, target.namespace); err != nil {
+			return restore(i) && restorable, err
+		}
+	}
+
+	err = fn()
+	// a thread left in the container's namespaces is terminated, so failing
+	// to restore it doesn't concern the caller
+	return restore(len(targets)) && restorable, err
+}
@@ -0,0 +1,186 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Traffic counters of a network interface
+type InterfaceStats struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// Traffic of a network interface of the container. For veth interfaces the
+// counters of the host side peer are reported as well, received and
+// transmitted being swapped compared to the container side.
+type NetworkStats struct {
+	Interface     string
+	Stats         InterfaceStats
+	HostInterface string
+	HostStats     InterfaceStats
+}
+
+// Returns the traffic counters of the network interfaces of the container
+func (lxc *Container) NetworkStats() ([]NetworkStats, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+	return lxc.networkStats()
+}
+
+func (lxc *Container) networkStats() ([]NetworkStats, error) {
+	pid := lxc.initPID()
+
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return nil, err
+	}
+	counters, err := parseNetDev(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = os.ReadFile("/proc/self/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	hostCounters, err := parseNetDev(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := lxc.vethPairs()
+	stats := make([]NetworkStats, 0, len(counters))
+	for name, counter := range counters {
+		s := NetworkStats{Interface: name, Stats: counter}
+
+		peer, ok := pairs[name]
+		if !ok {
+			peer = hostPeer(pid, name)
+		}
+		if hostCounter, ok := hostCounters[peer]; ok {
+			s.HostInterface = peer
+			s.HostStats = hostCounter
+		}
+		stats = append(stats, s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Interface < stats[j].Interface
+	})
+	return stats, nil
+}
+
+// Returns the host side names of the veth interfaces named in the
+// configuration, keyed by their names inside the container
+func (lxc *Container) vethPairs() map[string]string {
+	pairs := make(map[string]string)
+	for i := 0; ; i++ {
+		netType, ok, err := lxc.configValue(fmt.Sprintf("lxc.net.%d.type", i))
+		if err != nil || !ok {
+			break
+		}
+		if netType != "veth" {
+			continue
+		}
+		name, _, _ := lxc.configValue(fmt.Sprintf("lxc.net.%d.name", i))
+		pair, _, _ := lxc.configValue(fmt.Sprintf("lxc.net.%d.veth.pair", i))
+		if name != "" && pair != "" {
+			pairs[name] = pair
+		}
+	}
+	return pairs
+}
+
+// Finds the host side of a veth interface of the container through the
+// container's sysfs, the iflink of a veth being the ifindex of its peer
+func hostPeer(pid int, name string) string {
+	dir := fmt.Sprintf("/proc/%d/root/sys/class/net/%s", pid, name)
+	ifindex, err := readSysfsInt(filepath.Join(dir, "ifindex"))
+	if err != nil {
+		return ""
+	}
+	iflink, err := readSysfsInt(filepath.Join(dir, "iflink"))
+	if err != nil || iflink == ifindex {
+		return ""
+	}
+
+	links, err := os.ReadDir("/sys/class/net")
+	if err != nil {
+		return ""
+	}
+	for _, link := range links {
+		index, err := readSysfsInt(filepath.Join("/sys/class/net", link.Name(), "ifindex"))
+		if err == nil && index == iflink {
+			return link.Name()
+		}
+	}
+	return ""
+}
+
+func readSysfsInt(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// Parses /proc/net/dev, skipping its two header lines
+func parseNetDev(data string) (map[string]InterfaceStats, error) {
+	stats := make(map[string]InterfaceStats)
+
+	for _, line := range strings.Split(data, "\n") {
+		name, counters, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			continue
+		}
+
+		values := make([]uint64, 16)
+		for i := range values {
+			n, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s counters: %w", strings.TrimSpace(name), err)
+			}
+			values[i] = n
+		}
+		// receive: bytes packets errs drop fifo frame compressed multicast
+		// transmit: bytes packets errs drop fifo colls carrier compressed
+		stats[strings.TrimSpace(name)] = InterfaceStats{
+			RxBytes:   values[0],
+			RxPackets: values[1],
+			RxErrors:  values[2],
+			RxDropped: values[3],
+			TxBytes:   values[8],
+			TxPackets: values[9],
+			TxErrors:  values[10],
+			TxDropped: values[11],
+		}
+	}
+	return stats, nil
+}
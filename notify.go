@@ -0,0 +1,307 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	efdCloexec  = syscall.O_CLOEXEC
+	efdNonblock = syscall.O_NONBLOCK
+
+	// cgroup v2 has no memory thresholds, memory.current is polled instead
+	memoryPollInterval = time.Second
+)
+
+// Sent when the container runs out of memory
+type OOMEvent struct {
+	Time time.Time
+	// number of OOM events since the previous notification
+	Count uint64
+}
+
+// Sent when the memory usage of the container crosses the threshold, in
+// either direction
+type MemoryThresholdEvent struct {
+	Time      time.Time
+	Usage     ByteSize
+	Threshold ByteSize
+}
+
+// Returns a channel receiving an event whenever the container runs out of
+// memory. The channel is closed when ctx is done or the container stops.
+func (lxc *Container) NotifyOOM(ctx context.Context) (<-chan OOMEvent, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	dir, err := lxc.cgroupPath("memory")
+	if err != nil {
+		return nil, err
+	}
+	if unifiedCgroup() {
+		return notifyOOMV2(ctx, dir)
+	}
+	return notifyOOMV1(ctx, dir)
+}
+
+// Returns a channel receiving an event whenever the memory usage of the
+// container crosses threshold. The channel is closed when ctx is done or the
+// container stops.
+func (lxc *Container) NotifyMemoryThreshold(ctx context.Context, threshold ByteSize) (<-chan MemoryThresholdEvent, error) {
+	if threshold <= 0 {
+		return nil, &InvalidLimitError{Limit: "memory threshold", Value: threshold.String(), Reason: "must be positive"}
+	}
+
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	dir, err := lxc.cgroupPath("memory")
+	if err != nil {
+		return nil, err
+	}
+	if unifiedCgroup() {
+		return pollMemoryThreshold(ctx, filepath.Join(dir, "memory.current"), threshold)
+	}
+	return notifyMemoryThresholdV1(ctx, dir, threshold)
+}
+
+func notifyOOMV1(ctx context.Context, dir string) (<-chan OOMEvent, error) {
+	efd, err := registerCgroupEvent(dir, "memory.oom_control", "")
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan OOMEvent)
+	go func() {
+		defer close(ch)
+		watchFD(ctx, efd, func(buf []byte) bool {
+			// the eventfd is signalled as well when the cgroup is removed
+			if !cgroupExists(dir) {
+				return false
+			}
+			select {
+			case ch <- OOMEvent{Time: time.Now(), Count: eventCount(buf)}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return ch, nil
+}
+
+func notifyMemoryThresholdV1(ctx context.Context, dir string, threshold ByteSize) (<-chan MemoryThresholdEvent, error) {
+	path := filepath.Join(dir, "memory.usage_in_bytes")
+	efd, err := registerCgroupEvent(dir, "memory.usage_in_bytes", formatBytes(threshold))
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan MemoryThresholdEvent)
+	go func() {
+		defer close(ch)
+		watchFD(ctx, efd, func(buf []byte) bool {
+			usage, err := readCgroupBytes(path)
+			if err != nil {
+				return false
+			}
+			select {
+			case ch <- MemoryThresholdEvent{Time: time.Now(), Usage: usage, Threshold: threshold}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return ch, nil
+}
+
+// Registers an eventfd for the given file of a cgroup v1 memory controller
+// through cgroup.event_control, args are specific to the file
+func registerCgroupEvent(dir string, file string, args string) (*os.File, error) {
+	fd, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, efdCloexec|efdNonblock, 0)
+	if errno != 0 {
+		return nil, os.NewSyscallError("eventfd2", errno)
+	}
+	efd := os.NewFile(fd, "eventfd")
+
+	// the kernel needs the target only while registering
+	target, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		efd.Close()
+		return nil, err
+	}
+	defer target.Close()
+
+	// efd.Fd() would switch the descriptor to blocking mode
+	control := fmt.Sprintf("%d %d", fd, target.Fd())
+	if args != "" {
+		control += " " + args
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.event_control"), []byte(control), 0); err != nil {
+		efd.Close()
+		return nil, err
+	}
+	return efd, nil
+}
+
+// Returns the counter read from an eventfd
+func eventCount(buf []byte) uint64 {
+	if len(buf) < 8 {
+		return 0
+	}
+	return *(*uint64)(unsafe.Pointer(&buf[0]))
+}
+
+func notifyOOMV2(ctx context.Context, dir string) (<-chan OOMEvent, error) {
+	path := filepath.Join(dir, "memory.events")
+	events, err := readCgroupStats(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, path, syscall.IN_MODIFY); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	watcher := os.NewFile(uintptr(fd), "inotify")
+
+	ch := make(chan OOMEvent)
+	go func() {
+		defer close(ch)
+		last := events["oom"]
+		watchFD(ctx, watcher, func(buf []byte) bool {
+			// fails once the cgroup is removed
+			events, err := readCgroupStats(path)
+			if err != nil {
+				return false
+			}
+			if events["oom"] <= last {
+				return true
+			}
+			count := events["oom"] - last
+			last = events["oom"]
+
+			select {
+			case ch <- OOMEvent{Time: time.Now(), Count: count}:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return ch, nil
+}
+
+func pollMemoryThreshold(ctx context.Context, path string, threshold ByteSize) (<-chan MemoryThresholdEvent, error) {
+	usage, err := readCgroupBytes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan MemoryThresholdEvent)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(memoryPollInterval)
+		defer ticker.Stop()
+
+		above := usage >= threshold
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			usage, err := readCgroupBytes(path)
+			if err != nil {
+				return
+			}
+			if (usage >= threshold) == above {
+				continue
+			}
+			above = !above
+
+			select {
+			case ch <- MemoryThresholdEvent{Time: time.Now(), Usage: usage, Threshold: threshold}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// Calls fn with the data read from f, an eventfd or inotify descriptor, until
+// fn returns false or ctx is done. f is closed on return.
+func watchFD(ctx context.Context, f *os.File, fn func([]byte) bool) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		// unblocks the pending read
+		f.Close()
+	}()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		if !fn(buf[:n]) {
+			return
+		}
+	}
+}
+
+func cgroupExists(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "cgroup.event_control"))
+	return err == nil
+}
+
+// The notifications outlive the Container, so they read the cgroup files
+// directly instead of going through liblxc
+func readCgroupStats(path string) (map[string]uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCgroupStats(strings.Split(string(data), "\n"))
+}
+
+func readCgroupBytes(path string) (ByteSize, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return -1, err
+	}
+	return parseCgroupBytes(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,91 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+// OCI images for LXC containers
+//
+// This package creates containers from local OCI image layouts, as written by
+// image builders like buildah or skopeo. Only the parts of the image format
+// needed for that are modelled.
+package oci
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrInvalidLayout = errors.New("invalid image layout")
+)
+
+// media types of the documents, docker's ones are accepted as well
+const (
+	mediaTypeIndex          = "application/vnd.oci.image.index.v1+json"
+	mediaTypeManifest       = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerList     = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// annotation naming the images of a layout's index
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// Reference to a blob of the layout
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform an image is built for, with Go's GOOS and GOARCH values
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// index.json of a layout, or a multi-platform image
+type Index struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Manifests     []Descriptor `json:"manifests"`
+}
+
+// Configuration and layers of a single image
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Configuration blob of an image
+type Image struct {
+	Created      *time.Time  `json:"created,omitempty"`
+	Author       string      `json:"author,omitempty"`
+	Architecture string      `json:"architecture"`
+	OS           string      `json:"os"`
+	Config       ImageConfig `json:"config,omitempty"`
+}
+
+// Execution parameters of an image
+type ImageConfig struct {
+	// "user", "uid", "user:group" or "uid:gid"
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
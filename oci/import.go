@@ -0,0 +1,253 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package oci
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Options of Import
+type Options struct {
+	// image to import, matched against the org.opencontainers.image.ref.name
+	// annotation. The layout has to hold a single image if it is empty.
+	Ref string
+	// lxcpath of the container, the default one if empty
+	LXCPath string
+	// lxc.idmap values of unprivileged containers, like "u 0 100000 65536"
+	IDMap []string
+}
+
+// A single "key = value" line of a container's config file
+type ConfigItem struct {
+	Key   string
+	Value string
+}
+
+// LXC architectures of Go's GOARCH values
+var architectures = map[string]string{
+	"386":      "i686",
+	"amd64":    "amd64",
+	"arm":      "armhf",
+	"arm64":    "arm64",
+	"ppc64le":  "ppc64le",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+	"mips64le": "mips64el",
+}
+
+// configuration shipped with LXC, included if present on the host
+const (
+	commonConfig = "/usr/share/lxc/config/common.conf"
+	usernsConfig = "/usr/share/lxc/config/userns.conf"
+)
+
+// Creates the container name from an image of the OCI image layout at dir.
+// The image's entrypoint becomes the container's init, its environment,
+// working directory and user are set for it. Nothing is left behind if the
+// digests of the image don't match.
+func Import(dir string, name string, opts Options) (*lxc.Container, error) {
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := layout.Manifest(opts.Ref)
+	if err != nil {
+		return nil, err
+	}
+	image, err := layout.Image(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if image.OS != "" && image.OS != "linux" {
+		return nil, fmt.Errorf("%w: %s image", lxc.ErrNotSupported, image.OS)
+	}
+
+	containerDir, lxcpath, err := makeContainerDir(name, opts.LXCPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := create(layout, manifest, image, containerDir, name, opts.IDMap); err != nil {
+		os.RemoveAll(containerDir)
+		return nil, err
+	}
+	return lxc.NewContainer(name, lxcpath), nil
+}
+
+// Creates the directory of a new container, lxcpath defaults to the default
+// one. Returns the directory and the lxcpath.
+func makeContainerDir(name string, lxcpath string) (string, string, error) {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("%w: container name %q", lxc.ErrInvalidConfigValue, name)
+	}
+	if lxcpath == "" {
+		lxcpath = lxc.DefaultConfigPath()
+	}
+
+	dir := filepath.Join(lxcpath, name)
+	// fails if the container exists
+	if err := os.Mkdir(dir, 0750); err != nil {
+		return "", "", err
+	}
+	return dir, lxcpath, nil
+}
+
+func create(layout *Layout, manifest *Manifest, image *Image, dir string, name string, idmap []string) error {
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return err
+	}
+	ids, err := parseIDMap(idmap)
+	if err != nil {
+		return err
+	}
+	uid, gid, err := (&unpacker{ids: ids}).owner(0, 0)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(rootfs, uid, gid); err != nil {
+		return err
+	}
+	if err := layout.Unpack(manifest, rootfs, idmap); err != nil {
+		return err
+	}
+
+	items := []ConfigItem{
+		{"lxc.uts.name", name},
+		{"lxc.rootfs.path", rootfs},
+	}
+	if _, err := os.Stat(commonConfig); err == nil {
+		items = append(items, ConfigItem{"lxc.include", commonConfig})
+	}
+	if _, err := os.Stat(usernsConfig); err == nil && len(idmap) > 0 {
+		items = append(items, ConfigItem{"lxc.include", usernsConfig})
+	}
+	for _, entry := range idmap {
+		items = append(items, ConfigItem{"lxc.idmap", entry})
+	}
+	imageItems, err := ConfigItems(image, rootDir(rootfs))
+	if err != nil {
+		return err
+	}
+	return writeConfig(dir, "Imported from an OCI image", append(items, imageItems...))
+}
+
+func writeConfig(dir string, comment string, items []ConfigItem) error {
+	var config bytes.Buffer
+	fmt.Fprintf(&config, "# %s\n", comment)
+	for _, item := range items {
+		// values come from images and specs, a line break would add items
+		if strings.ContainsAny(item.Key, "\r\n") || strings.ContainsAny(item.Value, "\r\n") {
+			return fmt.Errorf("%w: %s = %q", lxc.ErrInvalidConfigValue, item.Key, item.Value)
+		}
+		fmt.Fprintf(&config, "%s = %s\n", item.Key, item.Value)
+	}
+	return os.WriteFile(filepath.Join(dir, "config"), config.Bytes(), 0640)
+}
+
+// Translates the image's configuration to config items. User and group names
+// are looked up in the unpacked rootfs, like the one returned by
+// Container.RootFS.
+func ConfigItems(image *Image, rootfs fs.FS) ([]ConfigItem, error) {
+	var items []ConfigItem
+
+	if arch, ok := architectures[image.Architecture]; ok {
+		items = append(items, ConfigItem{"lxc.arch", arch})
+	}
+
+	args := append(append([]string{}, image.Config.Entrypoint...), image.Config.Cmd...)
+	if len(args) > 0 {
+		quoted := make([]string, len(args))
+		for i, arg := range args {
+			quoted[i] = quoteArg(arg)
+		}
+		items = append(items, ConfigItem{"lxc.init.cmd", strings.Join(quoted, " ")})
+	}
+	if image.Config.WorkingDir != "" {
+		items = append(items, ConfigItem{"lxc.init.cwd", image.Config.WorkingDir})
+	}
+	for _, env := range image.Config.Env {
+		items = append(items, ConfigItem{"lxc.environment", env})
+	}
+
+	if image.Config.User != "" {
+		uid, gid, err := lookupUser(rootfs, image.Config.User)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, ConfigItem{"lxc.init.uid", strconv.Itoa(uid)}, ConfigItem{"lxc.init.gid", strconv.Itoa(gid)})
+	}
+	if image.Config.StopSignal != "" {
+		items = append(items, ConfigItem{"lxc.signal.halt", image.Config.StopSignal})
+	}
+	return items, nil
+}
+
+// liblxc splits lxc.init.cmd at spaces outside of quotes, without escapes
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t'\"") {
+		return arg
+	}
+	if strings.Contains(arg, "'") {
+		return `"` + arg + `"`
+	}
+	return "'" + arg + "'"
+}
+
+// Resolves a "user[:group]" of the image to IDs of the container, the group
+// defaults to the user's primary one
+func lookupUser(rootfs fs.FS, spec string) (int, int, error) {
+	user, group, hasGroup := strings.Cut(spec, ":")
+
+	passwd, _ := fs.ReadFile(rootfs, "etc/passwd")
+	uid, gid, found := -1, 0, false
+	for _, line := range strings.Split(string(passwd), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || (fields[0] != user && fields[2] != user) {
+			continue
+		}
+		u, err1 := strconv.Atoi(fields[2])
+		g, err2 := strconv.Atoi(fields[3])
+		if err1 == nil && err2 == nil {
+			uid, gid, found = u, g, true
+			break
+		}
+	}
+	if !found {
+		n, err := strconv.Atoi(user)
+		if err != nil || n < 0 {
+			return -1, -1, fmt.Errorf("%w: user %q of the image", lxc.ErrInvalidConfigValue, user)
+		}
+		uid = n
+	}
+	if !hasGroup {
+		return uid, gid, nil
+	}
+
+	if n, err := strconv.Atoi(group); err == nil && n >= 0 {
+		return uid, n, nil
+	}
+	groups, _ := fs.ReadFile(rootfs, "etc/group")
+	for _, line := range strings.Split(string(groups), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) >= 3 && fields[0] == group {
+			if g, err := strconv.Atoi(fields[2]); err == nil {
+				return uid, g, nil
+			}
+		}
+	}
+	return -1, -1, fmt.Errorf("%w: group %q of the image", lxc.ErrInvalidConfigValue, group)
+}
@@ -0,0 +1,210 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package oci
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// blobs larger than this aren't decoded as JSON documents
+const maxDocumentSize = 4 << 20
+
+// A directory holding images in the OCI image layout format
+type Layout struct {
+	dir string
+}
+
+// Returns the image layout at dir
+func OpenLayout(dir string) (*Layout, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "oci-layout"))
+	if err != nil {
+		return nil, err
+	}
+
+	var marker struct {
+		Version string `json:"imageLayoutVersion"`
+	}
+	if err := json.Unmarshal(data, &marker); err != nil {
+		return nil, fmt.Errorf("%w: oci-layout: %s", ErrInvalidLayout, err)
+	}
+	if !strings.HasPrefix(marker.Version, "1.") {
+		return nil, fmt.Errorf("%w: layout version %q", lxc.ErrNotSupported, marker.Version)
+	}
+	return &Layout{dir: dir}, nil
+}
+
+// Returns the index.json of the layout
+func (l *Layout) Index() (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(l.dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+	index := &Index{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("%w: index.json: %s", ErrInvalidLayout, err)
+	}
+	return index, nil
+}
+
+// Returns the manifest of the image named ref, or of the only image of the
+// layout if ref is empty. Multi-platform images are resolved to the manifest
+// of the host's architecture.
+func (l *Layout) Manifest(ref string) (*Manifest, error) {
+	index, err := l.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []Descriptor
+	for _, desc := range index.Manifests {
+		if ref == "" || desc.Annotations[annotationRefName] == ref {
+			candidates = append(candidates, desc)
+		}
+	}
+	switch {
+	case len(candidates) == 0 && ref != "":
+		return nil, fmt.Errorf("%w: %s", ErrImageNotFound, ref)
+	case len(candidates) == 0:
+		return nil, ErrImageNotFound
+	case len(candidates) > 1 && ref == "":
+		return nil, fmt.Errorf("%w: the layout holds %d images, a ref is needed", ErrImageNotFound, len(candidates))
+	}
+	return l.resolve(candidates[0], 0)
+}
+
+// Follows nested indexes down to a manifest
+func (l *Layout) resolve(desc Descriptor, depth int) (*Manifest, error) {
+	switch desc.MediaType {
+	case mediaTypeManifest, mediaTypeDockerManifest:
+		manifest := &Manifest{}
+		if err := l.readDocument(desc, manifest); err != nil {
+			return nil, err
+		}
+		return manifest, nil
+	case mediaTypeIndex, mediaTypeDockerList:
+	default:
+		return nil, fmt.Errorf("%w: media type %s", lxc.ErrNotSupported, desc.MediaType)
+	}
+
+	if depth > 8 {
+		return nil, fmt.Errorf("%w: too many nested indexes", ErrInvalidLayout)
+	}
+	index := &Index{}
+	if err := l.readDocument(desc, index); err != nil {
+		return nil, err
+	}
+	for _, m := range index.Manifests {
+		if m.Platform == nil || (m.Platform.OS == "linux" && m.Platform.Architecture == runtime.GOARCH) {
+			return l.resolve(m, depth+1)
+		}
+	}
+	return nil, fmt.Errorf("%w: no manifest for linux/%s", ErrImageNotFound, runtime.GOARCH)
+}
+
+// Returns the configuration of the image
+func (l *Layout) Image(m *Manifest) (*Image, error) {
+	image := &Image{}
+	if err := l.readDocument(m.Config, image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (l *Layout) readDocument(desc Descriptor, v interface{}) error {
+	if desc.Size > maxDocumentSize {
+		return fmt.Errorf("%w: %s is too large", ErrInvalidLayout, desc.Digest)
+	}
+	blob, err := l.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidLayout, desc.Digest, err)
+	}
+	return nil
+}
+
+// Returns the blob of desc, reading it fails with lxc.ErrChecksumMismatch
+// once its size or digest turn out wrong
+func (l *Layout) openBlob(desc Descriptor) (io.ReadCloser, error) {
+	algorithm, encoded, _ := strings.Cut(desc.Digest, ":")
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, fmt.Errorf("%w: digest %q", lxc.ErrNotSupported, desc.Digest)
+	}
+	// also keeps the digest from pointing outside of the layout
+	if len(encoded) != 2*h.Size() || strings.ToLower(encoded) != encoded {
+		return nil, fmt.Errorf("%w: digest %q", ErrInvalidLayout, desc.Digest)
+	}
+	if _, err := hex.DecodeString(encoded); err != nil {
+		return nil, fmt.Errorf("%w: digest %q", ErrInvalidLayout, desc.Digest)
+	}
+
+	f, err := os.Open(filepath.Join(l.dir, "blobs", algorithm, encoded))
+	if err != nil {
+		return nil, err
+	}
+	return &blobReader{f: f, h: h, desc: desc}, nil
+}
+
+// Verifies a blob while it is read
+type blobReader struct {
+	f    *os.File
+	h    hash.Hash
+	desc Descriptor
+	n    int64
+}
+
+func (b *blobReader) Read(p []byte) (int, error) {
+	n, err := b.f.Read(p)
+	b.h.Write(p[:n])
+	b.n += int64(n)
+
+	if b.n > b.desc.Size {
+		return n, fmt.Errorf("%w: %s is larger than %d bytes", lxc.ErrChecksumMismatch, b.desc.Digest, b.desc.Size)
+	}
+	if err == io.EOF {
+		if b.n != b.desc.Size {
+			return n, fmt.Errorf("%w: %s is %d bytes instead of %d", lxc.ErrChecksumMismatch, b.desc.Digest, b.n, b.desc.Size)
+		}
+		_, encoded, _ := strings.Cut(b.desc.Digest, ":")
+		if hex.EncodeToString(b.h.Sum(nil)) != encoded {
+			return n, fmt.Errorf("%w: %s", lxc.ErrChecksumMismatch, b.desc.Digest)
+		}
+	}
+	return n, err
+}
+
+func (b *blobReader) Close() error {
+	return b.f.Close()
+}
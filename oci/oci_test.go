@@ -0,0 +1,384 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/caglar10ur/lxc"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+// writes data as a blob of the layout
+func writeBlob(t *testing.T, dir string, mediaType string, data []byte) Descriptor {
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "blobs", "sha256", digest), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return Descriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(data))}
+}
+
+func writeDocument(t *testing.T, dir string, mediaType string, v interface{}) Descriptor {
+	data, _ := json.Marshal(v)
+	return writeBlob(t, dir, mediaType, data)
+}
+
+// a gzip compressed layer of the given headers, regular files get their
+// names as content
+func writeLayer(t *testing.T, dir string, headers ...*tar.Header) Descriptor {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range headers {
+		if hdr.Typeflag == tar.TypeReg {
+			hdr.Size = int64(len(hdr.Name))
+		}
+		if hdr.Mode == 0 {
+			hdr.Mode = 0644
+		}
+		tw.WriteHeader(hdr)
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write([]byte(hdr.Name))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return writeBlob(t, dir, "application/vnd.oci.image.layer.v1.tar+gzip", b.Bytes())
+}
+
+func writeLayout(t *testing.T, dir string, manifests ...Descriptor) {
+	os.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion": "1.0.0"}`), 0644)
+	data, _ := json.Marshal(Index{SchemaVersion: 2, Manifests: manifests})
+	os.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+func TestManifest(t *testing.T) {
+	dir := t.TempDir()
+	config := writeDocument(t, dir, "application/vnd.oci.image.config.v1+json", Image{Architecture: runtime.GOARCH, OS: "linux"})
+	manifest := writeDocument(t, dir, mediaTypeManifest, Manifest{SchemaVersion: 2, Config: config})
+	manifest.Platform = &Platform{Architecture: runtime.GOARCH, OS: "linux"}
+	other := writeDocument(t, dir, mediaTypeManifest, Manifest{SchemaVersion: 2})
+	other.Platform = &Platform{Architecture: "sparc", OS: "linux"}
+	index := writeDocument(t, dir, mediaTypeIndex, Index{SchemaVersion: 2, Manifests: []Descriptor{other, manifest}})
+	index.Annotations = map[string]string{annotationRefName: "latest"}
+	writeLayout(t, dir, index, Descriptor{MediaType: mediaTypeManifest, Annotations: map[string]string{annotationRefName: "old"}})
+
+	layout, err := OpenLayout(dir)
+	if err != nil {
+		t.Fatalf("OpenLayout failed: %s", err)
+	}
+	m, err := layout.Manifest("latest")
+	if err != nil {
+		t.Fatalf("Manifest failed: %s", err)
+	}
+	if m.Config.Digest != config.Digest {
+		t.Errorf("Manifest picked the wrong platform: %+v", m)
+	}
+	if _, err := layout.Manifest("missing"); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("Manifest found a missing image: %v", err)
+	}
+	if _, err := layout.Manifest(""); !errors.Is(err, ErrImageNotFound) {
+		t.Errorf("Manifest picked one of several images: %v", err)
+	}
+
+	// a blob modified after it was written
+	os.WriteFile(filepath.Join(dir, "blobs", "sha256", strings.TrimPrefix(config.Digest, "sha256:")), []byte(`{"os":"plan9"}`), 0644)
+	if _, err := layout.Image(m); !errors.Is(err, lxc.ErrChecksumMismatch) {
+		t.Errorf("Image accepted a modified blob: %v", err)
+	}
+	if _, err := layout.openBlob(Descriptor{Digest: "sha256:../../../etc/passwd"}); !errors.Is(err, ErrInvalidLayout) {
+		t.Errorf("openBlob accepted an invalid digest: %v", err)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	dir := t.TempDir()
+	base := writeLayer(t, dir,
+		&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "etc/hostname", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "bin/sh", Typeflag: tar.TypeReg, Mode: 0755, Uid: 1000},
+		&tar.Header{Name: "var/lib/apt/lists", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/"},
+	)
+	top := writeLayer(t, dir,
+		&tar.Header{Name: "bin/.wh.sh", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "var/lib/kept", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "var/lib/.wh..wh..opq", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "escape/tmp/file", Typeflag: tar.TypeReg},
+		&tar.Header{Name: "etc/hosts", Typeflag: tar.TypeLink, Linkname: "etc/hostname"},
+	)
+	layout := &Layout{dir: dir}
+	manifest := &Manifest{Layers: []Descriptor{base, top}}
+
+	rootfs := t.TempDir()
+	if err := layout.Unpack(manifest, rootfs, []string{"u 0 100000 65536", "g 0 100000 65536"}); err != nil {
+		t.Fatalf("Unpack failed: %s", err)
+	}
+	if err := fstest.TestFS(os.DirFS(rootfs), "etc/hostname", "etc/hosts", "var/lib/kept", "tmp/file"); err != nil {
+		t.Errorf("Unpack failed: %s", err)
+	}
+	for _, name := range []string{"bin/sh", "var/lib/apt"} {
+		if _, err := os.Lstat(filepath.Join(rootfs, name)); err == nil {
+			t.Errorf("Unpack didn't remove the whiteout %s", name)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(rootfs, "etc")); err != nil || fi.Sys().(*syscall.Stat_t).Uid != 100000 {
+		t.Errorf("Unpack didn't map the owner: %v", err)
+	}
+
+	// bin/sh is owned by 1000
+	if err := layout.Unpack(manifest, t.TempDir(), []string{"b 0 100000 100"}); !errors.Is(err, lxc.ErrInvalidConfigValue) {
+		t.Errorf("Unpack accepted an unmapped owner: %v", err)
+	}
+
+	rootfs = t.TempDir()
+	os.WriteFile(filepath.Join(rootfs, "kept"), nil, 0644)
+	for _, name := range []string{".wh..", ".wh..."} {
+		layer := writeLayer(t, dir, &tar.Header{Name: "etc/" + name, Typeflag: tar.TypeReg})
+		err := layout.Unpack(&Manifest{Layers: []Descriptor{layer}}, rootfs, nil)
+		if !errors.Is(err, ErrInvalidLayout) {
+			t.Errorf("Unpack accepted the whiteout %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "kept")); err != nil {
+		t.Errorf("a whiteout removed a file outside its directory: %v", err)
+	}
+}
+
+func TestConfigItems(t *testing.T) {
+	rootfs := fstest.MapFS{
+		"etc/passwd": {Data: []byte("root:x:0:0::/root:/bin/sh\nwww:x:33:33::/var/www:/bin/false\n")},
+		"etc/group":  {Data: []byte("root:x:0:\nadm:x:4:www\n")},
+	}
+	image := &Image{
+		Architecture: "arm64",
+		Config: ImageConfig{
+			User:       "www:adm",
+			Env:        []string{"PATH=/usr/bin:/bin"},
+			Entrypoint: []string{"/bin/sh", "-c"},
+			Cmd:        []string{"echo 'hi there'"},
+			WorkingDir: "/var/www",
+		},
+	}
+
+	items, err := ConfigItems(image, rootfs)
+	if err != nil {
+		t.Fatalf("ConfigItems failed: %s", err)
+	}
+	var lines []string
+	for _, item := range items {
+		lines = append(lines, item.Key+" = "+item.Value)
+	}
+	expected := []string{
+		"lxc.arch = arm64",
+		`lxc.init.cmd = /bin/sh -c "echo 'hi there'"`,
+		"lxc.init.cwd = /var/www",
+		"lxc.environment = PATH=/usr/bin:/bin",
+		"lxc.init.uid = 33",
+		"lxc.init.gid = 4",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("ConfigItems failed: %q", lines)
+	}
+
+	image.Config.User = "nobody"
+	if _, err := ConfigItems(image, rootfs); err == nil {
+		t.Errorf("ConfigItems accepted an unknown user...")
+	}
+}
+
+func TestSpecFromConfig(t *testing.T) {
+	config := `# comment
+lxc.utsname = rubik
+lxc.rootfs.path = dir:/var/lib/lxc/rubik/rootfs
+lxc.init.cmd = /bin/sh -c 'sleep 1'
+lxc.idmap = u 0 100000 65536
+lxc.idmap = g 0 100000 65536
+lxc.mount.auto = proc:mixed shmounts
+lxc.mount.entry = /srv/my\040data srv none bind,create=dir 0 0
+lxc.cap.drop = sys_admin sys_module
+lxc.cgroup2.memory.max = 512M
+lxc.cgroup2.memory.swap.max = 256M
+lxc.cgroup2.cpu.max = 50000 100000
+lxc.cgroup2.pids.max = 100
+lxc.prlimit.nofile = 1024:unlimited
+lxc.net.0.type = veth
+lxc.arch = amd64
+`
+	spec, unmapped := specFromConfig(parseConfig(config))
+
+	if spec.Hostname != "rubik" || spec.Root.Path != "/var/lib/lxc/rubik/rootfs" {
+		t.Errorf("specFromConfig failed: %+v %+v", spec, spec.Root)
+	}
+	if strings.Join(spec.Process.Args, "|") != "/bin/sh|-c|sleep 1" {
+		t.Errorf("specFromConfig failed: %q", spec.Process.Args)
+	}
+	if len(spec.Linux.UIDMappings) != 1 || spec.Linux.GIDMappings[0].HostID != 100000 {
+		t.Errorf("specFromConfig failed: %+v", spec.Linux)
+	}
+	if len(spec.Mounts) != 2 || spec.Mounts[1].Source != "/srv/my data" || spec.Mounts[1].Destination != "/srv" || spec.Mounts[1].Type != "bind" {
+		t.Errorf("specFromConfig failed: %+v", spec.Mounts)
+	}
+	for _, c := range spec.Process.Capabilities.Bounding {
+		if c == "CAP_SYS_ADMIN" || c == "CAP_SYS_MODULE" {
+			t.Errorf("specFromConfig kept %s", c)
+		}
+	}
+	if len(spec.Process.Capabilities.Bounding) != len(capabilities)-2 {
+		t.Errorf("specFromConfig failed: %v", spec.Process.Capabilities.Bounding)
+	}
+	memory := spec.Linux.Resources.Memory
+	if *memory.Limit != 512<<20 || *memory.Swap != 768<<20 {
+		t.Errorf("specFromConfig failed: %d %d", *memory.Limit, *memory.Swap)
+	}
+	if *spec.Linux.Resources.CPU.Quota != 50000 || spec.Linux.Resources.Pids.Limit != 100 {
+		t.Errorf("specFromConfig failed: %+v", spec.Linux.Resources)
+	}
+	if rlimit := spec.Process.Rlimits[0]; rlimit.Type != "RLIMIT_NOFILE" || rlimit.Soft != 1024 || rlimit.Hard != math.MaxUint64 {
+		t.Errorf("specFromConfig failed: %+v", rlimit)
+	}
+
+	var keys []string
+	for _, item := range unmapped {
+		keys = append(keys, item.Key+"="+item.Value)
+	}
+	if strings.Join(keys, ",") != "lxc.mount.auto=shmounts,lxc.net.0.type=veth,lxc.arch=amd64" {
+		t.Errorf("specFromConfig failed: %v", keys)
+	}
+}
+
+func TestSetResource(t *testing.T) {
+	for _, key := range []string{"cpu.shares", "cpu.cfs_quota_us", "cpu.cfs_period_us", "cpu.max"} {
+		resources := &LinuxResources{}
+		if setResource(resources, key, "lots") || resources.CPU != nil {
+			t.Errorf("setResource(%s) set an invalid value: %+v", key, resources.CPU)
+		}
+	}
+}
+
+func TestSpecFromLegacyConfig(t *testing.T) {
+	config := `lxc.utsname = rubik
+lxc.rootfs = /var/lib/lxc/rubik/rootfs
+lxc.network.type = none
+lxc.network.ipv4 = 10.0.3.2/24
+lxc.limit.nofile = 1024
+lxc.mount = /var/lib/lxc/rubik/fstab
+lxc.tty = 4
+`
+	items := parseConfig(config)
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	expected := "lxc.uts.name,lxc.rootfs.path,lxc.net.0.type,lxc.net.0.ipv4.address,lxc.prlimit.nofile,lxc.mount.fstab,lxc.tty.max"
+	if strings.Join(keys, ",") != expected {
+		t.Errorf("parseConfig failed: %v", keys)
+	}
+
+	spec, _ := specFromConfig(items)
+	if spec.Hostname != "rubik" {
+		t.Errorf("specFromConfig failed: %q", spec.Hostname)
+	}
+	// lxc.network.type = none shares the host's network
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Type == "network" {
+			t.Errorf("specFromConfig added a network namespace...")
+		}
+	}
+}
+
+func TestConfigFromSpec(t *testing.T) {
+	limit, swap := int64(512<<20), int64(768<<20)
+	shares, quota, period := uint64(1024), int64(50000), uint64(100000)
+	spec := &Spec{
+		Hostname: "rubik",
+		Root:     &Root{Path: "rootfs", Readonly: true},
+		Process: &Process{
+			Args:         []string{"/bin/sh", "-c", "sleep 1"},
+			Cwd:          "/",
+			Env:          []string{"PATH=/bin"},
+			Capabilities: &Capabilities{Bounding: []string{"CAP_CHOWN", "CAP_KILL"}},
+			Rlimits:      []Rlimit{{Type: "RLIMIT_NOFILE", Soft: 1024, Hard: 4096}},
+		},
+		Mounts: []Mount{
+			{Destination: "/proc", Type: "proc", Source: "proc"},
+			{Destination: "/dev", Type: "tmpfs", Source: "tmpfs"},
+			{Destination: "/data", Type: "bind", Source: "/srv/data", Options: []string{"rbind", "ro"}},
+		},
+		Linux: &Linux{
+			UIDMappings: []LinuxIDMapping{{ContainerID: 0, HostID: 100000, Size: 65536}},
+			Namespaces:  []LinuxNamespace{{Type: "pid"}, {Type: "ipc"}, {Type: "uts"}, {Type: "mount"}, {Type: "network", Path: "/run/netns/x"}},
+			Resources: &LinuxResources{
+				Memory: &LinuxMemory{Limit: &limit, Swap: &swap},
+				CPU:    &LinuxCPU{Shares: &shares, Quota: &quota, Period: &period},
+			},
+			Seccomp: json.RawMessage(`{"defaultAction": "SCMP_ACT_ERRNO"}`),
+		},
+	}
+
+	items, unmapped := configFromSpec(spec, "/srv/bundle/rootfs", true)
+	var lines []string
+	for _, item := range items {
+		lines = append(lines, item.Key+" = "+item.Value)
+	}
+	expected := []string{
+		"lxc.uts.name = rubik",
+		"lxc.rootfs.path = /srv/bundle/rootfs",
+		"lxc.rootfs.options = ro",
+		"lxc.init.cmd = /bin/sh -c 'sleep 1'",
+		"lxc.environment = PATH=/bin",
+		"lxc.cap.keep = chown kill",
+		"lxc.prlimit.nofile = 1024:4096",
+		"lxc.autodev = 1",
+		"lxc.mount.entry = /srv/data data none rbind,ro,create=dir 0 0",
+		"lxc.mount.auto = proc:mixed",
+		"lxc.idmap = u 0 100000 65536",
+		"lxc.net.0.type = empty",
+		"lxc.cgroup2.memory.max = 536870912",
+		"lxc.cgroup2.memory.swap.max = 268435456",
+		"lxc.cgroup2.cpu.weight = 39",
+		"lxc.cgroup2.cpu.max = 50000 100000",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("configFromSpec failed:\n%s", strings.Join(lines, "\n"))
+	}
+	if strings.Join(unmapped, ",") != "linux.namespaces.network.path,linux.seccomp" {
+		t.Errorf("configFromSpec failed: %v", unmapped)
+	}
+
+	// and back
+	back, _ := specFromConfig(items)
+	if strings.Join(back.Process.Args, "|") != "/bin/sh|-c|sleep 1" || *back.Linux.Resources.Memory.Swap != swap {
+		t.Errorf("specFromConfig failed: %+v", back.Process)
+	}
+	// a line break would add config items
+	lxcpath := t.TempDir()
+	spec.Hostname = "rubik\nlxc.hook.pre-start = /bin/true"
+	if _, _, err := FromOCISpec(spec, t.TempDir(), "rubik", lxcpath); !errors.Is(err, lxc.ErrInvalidConfigValue) {
+		t.Errorf("FromOCISpec accepted a line break: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(lxcpath, "rubik")); err == nil {
+		t.Errorf("FromOCISpec left the container directory behind...")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package oci
+
+import (
+	"encoding/json"
+)
+
+// version of the runtime specification written by ToOCISpec
+const specVersion = "1.0.2"
+
+// The config.json of an OCI runtime bundle, only the fields that have an LXC
+// counterpart, or are reported as unmappable, are modelled
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Hooks       json.RawMessage   `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// The container's init process
+type Process struct {
+	Terminal        bool          `json:"terminal,omitempty"`
+	User            User          `json:"user"`
+	Args            []string      `json:"args,omitempty"`
+	Env             []string      `json:"env,omitempty"`
+	Cwd             string        `json:"cwd"`
+	Capabilities    *Capabilities `json:"capabilities,omitempty"`
+	Rlimits         []Rlimit      `json:"rlimits,omitempty"`
+	NoNewPrivileges bool          `json:"noNewPrivileges,omitempty"`
+	ApparmorProfile string        `json:"apparmorProfile,omitempty"`
+	OOMScoreAdj     *int          `json:"oomScoreAdj,omitempty"`
+	SelinuxLabel    string        `json:"selinuxLabel,omitempty"`
+}
+
+// IDs of the init process, in the container's user namespace
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// Capability sets of the init process, like "CAP_CHOWN"
+type Capabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// A resource limit of the init process, like "RLIMIT_NOFILE"
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// The container's root filesystem, relative paths are relative to the bundle
+type Root struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// A mount done in the container, Destination is a container path
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Linux specific parts of the spec
+type Linux struct {
+	UIDMappings   []LinuxIDMapping  `json:"uidMappings,omitempty"`
+	GIDMappings   []LinuxIDMapping  `json:"gidMappings,omitempty"`
+	Sysctl        map[string]string `json:"sysctl,omitempty"`
+	Resources     *LinuxResources   `json:"resources,omitempty"`
+	CgroupsPath   string            `json:"cgroupsPath,omitempty"`
+	Namespaces    []LinuxNamespace  `json:"namespaces,omitempty"`
+	Devices       json.RawMessage   `json:"devices,omitempty"`
+	Seccomp       json.RawMessage   `json:"seccomp,omitempty"`
+	MaskedPaths   []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string          `json:"readonlyPaths,omitempty"`
+}
+
+// A range of IDs of the container mapped to the host
+type LinuxIDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// A namespace the container is created in, or joins if Path is set
+type LinuxNamespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// cgroup limits of the container
+type LinuxResources struct {
+	Devices json.RawMessage `json:"devices,omitempty"`
+	Memory  *LinuxMemory    `json:"memory,omitempty"`
+	CPU     *LinuxCPU       `json:"cpu,omitempty"`
+	Pids    *LinuxPids      `json:"pids,omitempty"`
+	BlockIO json.RawMessage `json:"blockIO,omitempty"`
+}
+
+// Memory limits in bytes, Swap limits memory and swap together
+type LinuxMemory struct {
+	Limit       *int64 `json:"limit,omitempty"`
+	Reservation *int64 `json:"reservation,omitempty"`
+	Swap        *int64 `json:"swap,omitempty"`
+}
+
+// CPU limits, Quota and Period are in microseconds
+type LinuxCPU struct {
+	Shares *uint64 `json:"shares,omitempty"`
+	Quota  *int64  `json:"quota,omitempty"`
+	Period *uint64 `json:"period,omitempty"`
+	Cpus   string  `json:"cpus,omitempty"`
+	Mems   string  `json:"mems,omitempty"`
+}
+
+type LinuxPids struct {
+	Limit int64 `json:"limit"`
+}
@@ -0,0 +1,812 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// capabilities known to LXC and the runtime spec, in the kernel's order
+var capabilities = []string{
+	"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+	"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+	"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+	"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+	"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+	"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+	"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+	"block_suspend", "audit_read", "perfmon", "bpf", "checkpoint_restore",
+}
+
+// options of lxc.mount.entry that liblxc handles itself
+var lxcMountOptions = map[string]bool{
+	"create=dir":  true,
+	"create=file": true,
+	"optional":    true,
+	"defaults":    true,
+}
+
+var fstabUnescaper = strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`)
+var fstabEscaper = strings.NewReplacer(" ", `\040`, "\t", `\011`, "\n", `\012`, `\`, `\134`)
+
+// Maps the configuration file of the container to an OCI runtime spec.
+// Returns the config items without an OCI counterpart alongside, included
+// files aren't followed.
+func ToOCISpec(c *lxc.Container) (*Spec, []ConfigItem, error) {
+	if !c.Defined() {
+		return nil, nil, lxc.ErrNotDefined
+	}
+
+	data, err := os.ReadFile(c.ConfigFileName())
+	if err != nil {
+		return nil, nil, err
+	}
+	spec, unmapped := specFromConfig(parseConfig(string(data)))
+	return spec, unmapped, nil
+}
+
+// Returns the items of a config file, with legacy keys in their modern
+// spelling
+func parseConfig(data string) []ConfigItem {
+	var items []ConfigItem
+	for _, line := range strings.Split(string(lxc.ModernConfig([]byte(data))), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		items = append(items, ConfigItem{Key: key, Value: value})
+	}
+	return items
+}
+
+func specFromConfig(items []ConfigItem) (*Spec, []ConfigItem) {
+	spec := &Spec{
+		Version: specVersion,
+		Process: &Process{Cwd: "/"},
+		Linux:   &Linux{},
+	}
+	resources := &LinuxResources{}
+	var (
+		unmapped   []ConfigItem
+		keep, drop []string
+		keepSet    bool
+		readonly   bool
+		network    = true
+		// cgroup2's memory.swap.max excludes the memory limit
+		swap *ConfigItem
+	)
+
+	for i, item := range items {
+		key, value := item.Key, item.Value
+		ok := true
+
+		switch {
+		case key == "lxc.uts.name":
+			spec.Hostname = value
+		case key == "lxc.rootfs.path":
+			kind, dir, found := strings.Cut(value, ":")
+			switch {
+			case !found:
+				spec.Root = &Root{Path: value}
+			case kind == "dir":
+				spec.Root = &Root{Path: dir}
+			default:
+				ok = false
+			}
+		case key == "lxc.rootfs.options":
+			for _, option := range strings.Split(value, ",") {
+				if option == "ro" {
+					readonly = true
+				} else if option != "rw" {
+					ok = false
+				}
+			}
+		case key == "lxc.init.cmd" || key == "lxc.execute.cmd":
+			spec.Process.Args = splitArgs(value)
+		case key == "lxc.init.cwd":
+			spec.Process.Cwd = value
+		case key == "lxc.init.uid" || key == "lxc.init.gid":
+			id, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				ok = false
+			} else if key == "lxc.init.uid" {
+				spec.Process.User.UID = uint32(id)
+			} else {
+				spec.Process.User.GID = uint32(id)
+			}
+		case key == "lxc.environment":
+			// a bare name passes the variable of the host on
+			if !strings.Contains(value, "=") {
+				ok = false
+				break
+			}
+			spec.Process.Env = append(spec.Process.Env, value)
+		case key == "lxc.idmap":
+			ranges, err := parseIDMap([]string{value})
+			if err != nil {
+				ok = false
+				break
+			}
+			r := ranges[0]
+			mapping := LinuxIDMapping{ContainerID: uint32(r.inside), HostID: uint32(r.outside), Size: uint32(r.count)}
+			if r.kind != "g" {
+				spec.Linux.UIDMappings = append(spec.Linux.UIDMappings, mapping)
+			}
+			if r.kind != "u" {
+				spec.Linux.GIDMappings = append(spec.Linux.GIDMappings, mapping)
+			}
+		case key == "lxc.mount.entry":
+			var m Mount
+			if m, ok = parseMountEntry(value); ok {
+				spec.Mounts = append(spec.Mounts, m)
+			}
+		case key == "lxc.mount.auto":
+			// reported token by token
+			for _, token := range strings.Fields(value) {
+				if m, found := autoMount(token); found {
+					spec.Mounts = append(spec.Mounts, m)
+				} else {
+					unmapped = append(unmapped, ConfigItem{Key: key, Value: token})
+				}
+			}
+		case key == "lxc.cap.keep":
+			keepSet = true
+			keep = append(keep, strings.Fields(value)...)
+		case key == "lxc.cap.drop":
+			drop = append(drop, strings.Fields(value)...)
+		case key == "lxc.apparmor.profile":
+			spec.Process.ApparmorProfile = value
+		case key == "lxc.selinux.context":
+			spec.Process.SelinuxLabel = value
+		case key == "lxc.no_new_privs":
+			b, err := strconv.ParseBool(value)
+			spec.Process.NoNewPrivileges, ok = b, err == nil
+		case key == "lxc.proc.oom_score_adj":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				ok = false
+				break
+			}
+			spec.Process.OOMScoreAdj = &n
+		case strings.HasPrefix(key, "lxc.prlimit."):
+			var rlimit Rlimit
+			if rlimit, ok = parseRlimit(strings.TrimPrefix(key, "lxc.prlimit."), value); ok {
+				spec.Process.Rlimits = append(spec.Process.Rlimits, rlimit)
+			}
+		case strings.HasPrefix(key, "lxc.sysctl."):
+			if spec.Linux.Sysctl == nil {
+				spec.Linux.Sysctl = make(map[string]string)
+			}
+			spec.Linux.Sysctl[strings.TrimPrefix(key, "lxc.sysctl.")] = value
+		case key == "lxc.cgroup.dir":
+			spec.Linux.CgroupsPath = "/" + strings.TrimPrefix(value, "/")
+		case key == "lxc.cgroup2.memory.swap.max":
+			swap = &items[i]
+		case strings.HasPrefix(key, "lxc.cgroup.") || strings.HasPrefix(key, "lxc.cgroup2."):
+			_, controllerKey, _ := strings.Cut(strings.TrimPrefix(key, "lxc."), ".")
+			ok = setResource(resources, controllerKey, value)
+		case key == "lxc.net.0.type" && value == "none":
+			network = false
+		case key == "lxc.net.0.type" && value == "empty":
+			// a network namespace with only the loopback interface
+		default:
+			ok = false
+		}
+
+		if !ok {
+			unmapped = append(unmapped, item)
+		}
+	}
+
+	if spec.Root != nil {
+		spec.Root.Readonly = readonly
+	}
+
+	if swap != nil {
+		limit, err := parseLimit(swap.Value)
+		switch {
+		case err == nil && limit == nil:
+		case err == nil && resources.Memory != nil && resources.Memory.Limit != nil:
+			total := *resources.Memory.Limit + *limit
+			resources.Memory.Swap = &total
+		default:
+			unmapped = append(unmapped, *swap)
+		}
+	}
+	if resources.Memory != nil || resources.CPU != nil || resources.Pids != nil {
+		spec.Linux.Resources = resources
+	}
+
+	// liblxc keeps all capabilities unless told otherwise
+	caps := keep
+	if !keepSet {
+		dropped := make(map[string]bool)
+		for _, c := range drop {
+			dropped[strings.ToLower(c)] = true
+		}
+		caps = nil
+		for _, c := range capabilities {
+			if !dropped[c] {
+				caps = append(caps, c)
+			}
+		}
+	}
+	var names []string
+	for _, c := range caps {
+		if c != "none" {
+			names = append(names, "CAP_"+strings.ToUpper(c))
+		}
+	}
+	spec.Process.Capabilities = &Capabilities{Bounding: names, Effective: names, Permitted: names}
+
+	for _, ns := range []string{"pid", "ipc", "uts", "mount", "cgroup"} {
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, LinuxNamespace{Type: ns})
+	}
+	if network {
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, LinuxNamespace{Type: "network"})
+	}
+	if len(spec.Linux.UIDMappings) > 0 || len(spec.Linux.GIDMappings) > 0 {
+		spec.Linux.Namespaces = append(spec.Linux.Namespaces, LinuxNamespace{Type: "user"})
+	}
+	return spec, unmapped
+}
+
+// Splits lxc.init.cmd like liblxc, at spaces outside of quotes
+func splitArgs(value string) []string {
+	var args []string
+	var current strings.Builder
+	var quote rune
+	inArg := false
+
+	for _, r := range value {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// Parses "source target type options dump pass", targets are relative to the
+// rootfs
+func parseMountEntry(value string) (Mount, bool) {
+	fields := strings.Fields(value)
+	if len(fields) < 4 || path.IsAbs(fields[1]) {
+		return Mount{}, false
+	}
+
+	m := Mount{
+		Source:      fstabUnescaper.Replace(fields[0]),
+		Destination: path.Clean("/" + fstabUnescaper.Replace(fields[1])),
+		Type:        fields[2],
+	}
+	bind := false
+	for _, option := range strings.Split(fields[3], ",") {
+		if lxcMountOptions[option] {
+			continue
+		}
+		if option == "bind" || option == "rbind" {
+			bind = true
+		}
+		m.Options = append(m.Options, option)
+	}
+	if bind && m.Type == "none" {
+		m.Type = "bind"
+	}
+	return m, true
+}
+
+// Mounts of lxc.mount.auto tokens like "proc:mixed" or "sys:ro"
+func autoMount(token string) (Mount, bool) {
+	kind, mode, _ := strings.Cut(token, ":")
+	options := []string{"nosuid", "noexec", "nodev"}
+
+	switch kind {
+	case "proc":
+		return Mount{Destination: "/proc", Type: "proc", Source: "proc", Options: options}, true
+	case "sys":
+		if mode != "rw" {
+			options = append(options, "ro")
+		}
+		return Mount{Destination: "/sys", Type: "sysfs", Source: "sysfs", Options: options}, true
+	case "cgroup", "cgroup-full":
+		if !strings.HasPrefix(mode, "rw") {
+			options = append(options, "ro")
+		}
+		return Mount{Destination: "/sys/fs/cgroup", Type: "cgroup", Source: "cgroup", Options: options}, true
+	}
+	return Mount{}, false
+}
+
+// Parses lxc.prlimit values, "unlimited", "limit" or "soft:hard"
+func parseRlimit(name string, value string) (Rlimit, bool) {
+	parse := func(s string) (uint64, bool) {
+		if s == "unlimited" {
+			return math.MaxUint64, true
+		}
+		n, err := strconv.ParseUint(s, 10, 64)
+		return n, err == nil
+	}
+
+	soft, hard, found := strings.Cut(value, ":")
+	if !found {
+		hard = soft
+	}
+	rlimit := Rlimit{Type: "RLIMIT_" + strings.ToUpper(name)}
+	var ok1, ok2 bool
+	rlimit.Soft, ok1 = parse(soft)
+	rlimit.Hard, ok2 = parse(hard)
+	return rlimit, ok1 && ok2
+}
+
+// Parses byte limits, nil for "max" or -1
+func parseLimit(value string) (*int64, error) {
+	if value == "max" || value == "-1" {
+		return nil, nil
+	}
+	size, err := lxc.ParseByteSize(value)
+	if err != nil {
+		return nil, err
+	}
+	n := int64(size)
+	return &n, nil
+}
+
+// Sets the resource of a cgroup v1 or v2 key like "memory.max"
+func setResource(resources *LinuxResources, key string, value string) bool {
+	memory := func() *LinuxMemory {
+		if resources.Memory == nil {
+			resources.Memory = &LinuxMemory{}
+		}
+		return resources.Memory
+	}
+	cpu := func() *LinuxCPU {
+		if resources.CPU == nil {
+			resources.CPU = &LinuxCPU{}
+		}
+		return resources.CPU
+	}
+
+	switch key {
+	case "memory.limit_in_bytes", "memory.max":
+		limit, err := parseLimit(value)
+		if err == nil && limit != nil {
+			memory().Limit = limit
+		}
+		return err == nil
+	case "memory.soft_limit_in_bytes", "memory.low":
+		limit, err := parseLimit(value)
+		if err == nil && limit != nil {
+			memory().Reservation = limit
+		}
+		return err == nil
+	case "memory.memsw.limit_in_bytes":
+		limit, err := parseLimit(value)
+		if err == nil && limit != nil {
+			memory().Swap = limit
+		}
+		return err == nil
+	case "cpu.shares":
+		shares, err := strconv.ParseUint(value, 10, 64)
+		if err == nil {
+			cpu().Shares = &shares
+		}
+		return err == nil
+	case "cpu.weight":
+		weight, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || weight < 1 || weight > 10000 {
+			return false
+		}
+		// the inverse of the conversion of runc and the kernel docs
+		shares := 2 + (weight-1)*262142/9999
+		cpu().Shares = &shares
+		return true
+	case "cpu.cfs_quota_us":
+		quota, err := strconv.ParseInt(value, 10, 64)
+		if err == nil {
+			cpu().Quota = &quota
+		}
+		return err == nil
+	case "cpu.cfs_period_us":
+		period, err := strconv.ParseUint(value, 10, 64)
+		if err == nil {
+			cpu().Period = &period
+		}
+		return err == nil
+	case "cpu.max":
+		fields := strings.Fields(value)
+		if len(fields) == 0 || len(fields) > 2 {
+			return false
+		}
+		if fields[0] != "max" {
+			quota, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil {
+				return false
+			}
+			cpu().Quota = &quota
+		}
+		if len(fields) == 2 {
+			period, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return false
+			}
+			cpu().Period = &period
+		}
+		return true
+	case "cpuset.cpus":
+		cpu().Cpus = value
+		return true
+	case "cpuset.mems":
+		cpu().Mems = value
+		return true
+	case "pids.max":
+		if value == "max" {
+			return true
+		}
+		limit, err := strconv.ParseInt(value, 10, 64)
+		resources.Pids = &LinuxPids{Limit: limit}
+		return err == nil
+	}
+	return false
+}
+
+// Returns the config.json of the bundle
+func ReadSpec(bundle string) (*Spec, error) {
+	data, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("%w: config.json: %s", lxc.ErrInvalidConfigValue, err)
+	}
+	return spec, nil
+}
+
+// Creates the container name from the spec of the bundle, using the bundle's
+// rootfs in place. Returns the parts of the spec without an LXC counterpart
+// alongside, like "linux.seccomp".
+func FromOCISpec(spec *Spec, bundle string, name string, lxcpath string) (*lxc.Container, []string, error) {
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, nil, fmt.Errorf("%w: the spec has no root", lxc.ErrInvalidConfigValue)
+	}
+	rootfs := spec.Root.Path
+	if !filepath.IsAbs(rootfs) {
+		abs, err := filepath.Abs(filepath.Join(bundle, rootfs))
+		if err != nil {
+			return nil, nil, err
+		}
+		rootfs = abs
+	}
+
+	items, unmapped := configFromSpec(spec, rootfs, lxc.DetectCgroupVersion() == lxc.CGROUP_V2)
+	dir, lxcpath, err := makeContainerDir(name, lxcpath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeConfig(dir, "Created from an OCI runtime spec", items); err != nil {
+		os.RemoveAll(dir)
+		return nil, nil, err
+	}
+	return lxc.NewContainer(name, lxcpath), unmapped, nil
+}
+
+func configFromSpec(spec *Spec, rootfs string, cgroup2 bool) ([]ConfigItem, []string) {
+	var items []ConfigItem
+	var unmapped []string
+	add := func(key string, value string) {
+		items = append(items, ConfigItem{Key: key, Value: value})
+	}
+
+	if spec.Hostname != "" {
+		add("lxc.uts.name", spec.Hostname)
+	}
+	add("lxc.rootfs.path", rootfs)
+	if spec.Root != nil && spec.Root.Readonly {
+		add("lxc.rootfs.options", "ro")
+	}
+	if len(spec.Hooks) > 0 && string(spec.Hooks) != "null" && string(spec.Hooks) != "{}" {
+		unmapped = append(unmapped, "hooks")
+	}
+
+	if p := spec.Process; p != nil {
+		if len(p.Args) > 0 {
+			quoted := make([]string, len(p.Args))
+			for i, arg := range p.Args {
+				quoted[i] = quoteArg(arg)
+			}
+			add("lxc.init.cmd", strings.Join(quoted, " "))
+		}
+		if p.Cwd != "" && p.Cwd != "/" {
+			add("lxc.init.cwd", p.Cwd)
+		}
+		if p.User.UID != 0 || p.User.GID != 0 {
+			add("lxc.init.uid", strconv.FormatUint(uint64(p.User.UID), 10))
+			add("lxc.init.gid", strconv.FormatUint(uint64(p.User.GID), 10))
+		}
+		if len(p.User.AdditionalGids) > 0 {
+			unmapped = append(unmapped, "process.user.additionalGids")
+		}
+		for _, env := range p.Env {
+			add("lxc.environment", env)
+		}
+
+		if caps := p.Capabilities; caps != nil {
+			if keep := capabilityNames(caps.Bounding); len(keep) < len(capabilities) {
+				if len(keep) == 0 {
+					keep = []string{"none"}
+				}
+				add("lxc.cap.keep", strings.Join(keep, " "))
+			}
+			if len(caps.Inheritable) > 0 {
+				unmapped = append(unmapped, "process.capabilities.inheritable")
+			}
+			if len(caps.Ambient) > 0 {
+				unmapped = append(unmapped, "process.capabilities.ambient")
+			}
+		}
+		for _, rlimit := range p.Rlimits {
+			name := strings.ToLower(strings.TrimPrefix(rlimit.Type, "RLIMIT_"))
+			add("lxc.prlimit."+name, rlimitValue(rlimit.Soft)+":"+rlimitValue(rlimit.Hard))
+		}
+		if p.NoNewPrivileges {
+			add("lxc.no_new_privs", "1")
+		}
+		if p.ApparmorProfile != "" {
+			add("lxc.apparmor.profile", p.ApparmorProfile)
+		}
+		if p.SelinuxLabel != "" {
+			add("lxc.selinux.context", p.SelinuxLabel)
+		}
+		if p.OOMScoreAdj != nil {
+			add("lxc.proc.oom_score_adj", strconv.Itoa(*p.OOMScoreAdj))
+		}
+	}
+
+	var auto []string
+	for _, m := range spec.Mounts {
+		ro := false
+		for _, option := range m.Options {
+			ro = ro || option == "ro"
+		}
+		mode := "rw"
+		if ro {
+			mode = "ro"
+		}
+
+		switch {
+		case m.Destination == "/proc" && m.Type == "proc":
+			auto = append(auto, "proc:mixed")
+		case m.Destination == "/sys" && m.Type == "sysfs":
+			auto = append(auto, "sys:"+mode)
+		case m.Destination == "/sys/fs/cgroup" && (m.Type == "cgroup" || m.Type == "cgroup2"):
+			auto = append(auto, "cgroup:"+mode)
+		case m.Destination == "/dev" && m.Type == "tmpfs":
+			add("lxc.autodev", "1")
+		case m.Destination == "/dev/pts" && m.Type == "devpts":
+			// mounted by liblxc for the console and ttys
+		default:
+			add("lxc.mount.entry", mountEntry(m))
+		}
+	}
+	if len(auto) > 0 {
+		add("lxc.mount.auto", strings.Join(auto, " "))
+	}
+
+	linux := spec.Linux
+	if linux == nil {
+		return items, unmapped
+	}
+	for _, m := range linux.UIDMappings {
+		add("lxc.idmap", fmt.Sprintf("u %d %d %d", m.ContainerID, m.HostID, m.Size))
+	}
+	for _, m := range linux.GIDMappings {
+		add("lxc.idmap", fmt.Sprintf("g %d %d %d", m.ContainerID, m.HostID, m.Size))
+	}
+
+	keys := make([]string, 0, len(linux.Sysctl))
+	for key := range linux.Sysctl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		add("lxc.sysctl."+key, linux.Sysctl[key])
+	}
+
+	if linux.CgroupsPath != "" {
+		// systemd's slice:prefix:name form
+		if strings.Contains(linux.CgroupsPath, ":") {
+			unmapped = append(unmapped, "linux.cgroupsPath")
+		} else {
+			add("lxc.cgroup.dir", strings.TrimPrefix(linux.CgroupsPath, "/"))
+		}
+	}
+
+	namespaces := make(map[string]bool)
+	for _, ns := range linux.Namespaces {
+		namespaces[ns.Type] = true
+		if ns.Path != "" {
+			unmapped = append(unmapped, "linux.namespaces."+ns.Type+".path")
+		}
+	}
+	if namespaces["network"] {
+		add("lxc.net.0.type", "empty")
+	} else {
+		add("lxc.net.0.type", "none")
+	}
+	for _, ns := range []string{"pid", "ipc", "uts", "mount"} {
+		// liblxc always creates them
+		if !namespaces[ns] {
+			unmapped = append(unmapped, "linux.namespaces."+ns)
+		}
+	}
+
+	if r := linux.Resources; r != nil {
+		resourceItems, resourceUnmapped := resourceConfig(r, cgroup2)
+		items = append(items, resourceItems...)
+		unmapped = append(unmapped, resourceUnmapped...)
+	}
+	for name, raw := range map[string]json.RawMessage{"linux.devices": linux.Devices, "linux.seccomp": linux.Seccomp} {
+		if len(raw) > 0 && string(raw) != "null" && string(raw) != "[]" {
+			unmapped = append(unmapped, name)
+		}
+	}
+	if len(linux.MaskedPaths) > 0 {
+		unmapped = append(unmapped, "linux.maskedPaths")
+	}
+	if len(linux.ReadonlyPaths) > 0 {
+		unmapped = append(unmapped, "linux.readonlyPaths")
+	}
+	sort.Strings(unmapped)
+	return items, unmapped
+}
+
+// Returns the lowercase names of the capabilities, like "sys_admin"
+func capabilityNames(caps []string) []string {
+	names := make([]string, 0, len(caps))
+	for _, c := range caps {
+		names = append(names, strings.ToLower(strings.TrimPrefix(c, "CAP_")))
+	}
+	return names
+}
+
+func rlimitValue(value uint64) string {
+	if value == math.MaxUint64 {
+		return "unlimited"
+	}
+	return strconv.FormatUint(value, 10)
+}
+
+// Formats a mount as an lxc.mount.entry, missing targets are created
+func mountEntry(m Mount) string {
+	options := m.Options
+	create := "create=dir"
+	if fi, err := os.Stat(m.Source); err == nil && !fi.IsDir() {
+		create = "create=file"
+	}
+	options = append(append([]string{}, options...), create)
+
+	fstype := m.Type
+	if fstype == "" || fstype == "bind" {
+		fstype = "none"
+	}
+	source := m.Source
+	if source == "" {
+		source = fstype
+	}
+	return fmt.Sprintf("%s %s %s %s 0 0", fstabEscaper.Replace(source),
+		fstabEscaper.Replace(strings.TrimPrefix(path.Clean(m.Destination), "/")), fstype, strings.Join(options, ","))
+}
+
+func resourceConfig(r *LinuxResources, cgroup2 bool) ([]ConfigItem, []string) {
+	var items []ConfigItem
+	var unmapped []string
+	// takes the key of the host's cgroup version
+	add := func(v1 string, v2 string, value string) {
+		if cgroup2 {
+			items = append(items, ConfigItem{Key: "lxc.cgroup2." + v2, Value: value})
+		} else {
+			items = append(items, ConfigItem{Key: "lxc.cgroup." + v1, Value: value})
+		}
+	}
+
+	if m := r.Memory; m != nil {
+		if m.Limit != nil && *m.Limit > 0 {
+			add("memory.limit_in_bytes", "memory.max", strconv.FormatInt(*m.Limit, 10))
+		}
+		if m.Reservation != nil && *m.Reservation > 0 {
+			add("memory.soft_limit_in_bytes", "memory.low", strconv.FormatInt(*m.Reservation, 10))
+		}
+		if m.Swap != nil && *m.Swap > 0 {
+			switch {
+			case !cgroup2:
+				add("memory.memsw.limit_in_bytes", "", strconv.FormatInt(*m.Swap, 10))
+			case m.Limit != nil && *m.Swap >= *m.Limit:
+				add("", "memory.swap.max", strconv.FormatInt(*m.Swap-*m.Limit, 10))
+			default:
+				unmapped = append(unmapped, "linux.resources.memory.swap")
+			}
+		}
+	}
+
+	if c := r.CPU; c != nil {
+		if c.Shares != nil && *c.Shares >= 2 {
+			value := strconv.FormatUint(*c.Shares, 10)
+			if cgroup2 {
+				// the conversion of runc and the kernel docs
+				value = strconv.FormatUint(1+(*c.Shares-2)*9999/262142, 10)
+			}
+			add("cpu.shares", "cpu.weight", value)
+		}
+		if cgroup2 && (c.Quota != nil || c.Period != nil) {
+			quota, period := "max", "100000"
+			if c.Quota != nil && *c.Quota > 0 {
+				quota = strconv.FormatInt(*c.Quota, 10)
+			}
+			if c.Period != nil {
+				period = strconv.FormatUint(*c.Period, 10)
+			}
+			add("", "cpu.max", quota+" "+period)
+		} else if !cgroup2 {
+			if c.Quota != nil {
+				add("cpu.cfs_quota_us", "", strconv.FormatInt(*c.Quota, 10))
+			}
+			if c.Period != nil {
+				add("cpu.cfs_period_us", "", strconv.FormatUint(*c.Period, 10))
+			}
+		}
+		if c.Cpus != "" {
+			add("cpuset.cpus", "cpuset.cpus", c.Cpus)
+		}
+		if c.Mems != "" {
+			add("cpuset.mems", "cpuset.mems", c.Mems)
+		}
+	}
+
+	if r.Pids != nil && r.Pids.Limit > 0 {
+		add("pids.max", "pids.max", strconv.FormatInt(r.Pids.Limit, 10))
+	}
+	for name, raw := range map[string]json.RawMessage{"linux.resources.devices": r.Devices, "linux.resources.blockIO": r.BlockIO} {
+		if len(raw) > 0 && string(raw) != "null" && string(raw) != "[]" && string(raw) != "{}" {
+			unmapped = append(unmapped, name)
+		}
+	}
+	return items, unmapped
+}
@@ -0,0 +1,358 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"github.com/caglar10ur/lxc"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// symlinks followed while resolving a single path, like the kernel's limit
+	maxSymlinks = 40
+
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// Applies the layers of the image to rootfs, in order. The owners of the files
+// are mapped to the host with idmap, lxc.idmap values like "u 0 100000 65536".
+// Files keep the owners of the image if idmap is empty.
+func (l *Layout) Unpack(m *Manifest, rootfs string, idmap []string) error {
+	ids, err := parseIDMap(idmap)
+	if err != nil {
+		return err
+	}
+	u := &unpacker{root: rootfs, ids: ids}
+
+	for _, layer := range m.Layers {
+		if err := l.unpackLayer(u, layer); err != nil {
+			return fmt.Errorf("layer %s: %w", layer.Digest, err)
+		}
+	}
+	return nil
+}
+
+func (l *Layout) unpackLayer(u *unpacker, desc Descriptor) error {
+	blob, err := l.openBlob(desc)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	var r io.Reader = blob
+	switch {
+	case strings.HasSuffix(desc.MediaType, "+gzip") || strings.HasSuffix(desc.MediaType, ".tar.gzip"):
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case strings.HasSuffix(desc.MediaType, ".tar"):
+	default:
+		return fmt.Errorf("%w: media type %s", lxc.ErrNotSupported, desc.MediaType)
+	}
+
+	u.layer = make(map[string]bool)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := u.apply(hdr, tr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+	// the digest is verified once the whole blob is read
+	_, err = io.Copy(io.Discard, blob)
+	return err
+}
+
+// a range of an lxc.idmap value, kind is u, g or b for both
+type idRange struct {
+	kind    string
+	inside  int
+	outside int
+	count   int
+}
+
+func parseIDMap(entries []string) ([]idRange, error) {
+	var ranges []idRange
+	for _, entry := range entries {
+		fields := strings.Fields(entry)
+		if len(fields) != 4 || (fields[0] != "u" && fields[0] != "g" && fields[0] != "b") {
+			return nil, fmt.Errorf("%w: lxc.idmap = %s", lxc.ErrInvalidConfigValue, entry)
+		}
+		r := idRange{kind: fields[0]}
+		for i, v := range []*int{&r.inside, &r.outside, &r.count} {
+			n, err := strconv.Atoi(fields[i+1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%w: lxc.idmap = %s", lxc.ErrInvalidConfigValue, entry)
+			}
+			*v = n
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// Maps a uid (kind u) or gid (kind g) of the image to the host
+func mapID(ranges []idRange, kind string, id int) (int, error) {
+	mapped := false
+	for _, r := range ranges {
+		if r.kind != kind && r.kind != "b" {
+			continue
+		}
+		mapped = true
+		if id >= r.inside && id < r.inside+r.count {
+			return r.outside + id - r.inside, nil
+		}
+	}
+	if !mapped {
+		return id, nil
+	}
+	return -1, fmt.Errorf("%w: %sid %d is not mapped to the host", lxc.ErrInvalidConfigValue, kind, id)
+}
+
+type unpacker struct {
+	root string
+	ids  []idRange
+	// host paths created by the current layer, kept by opaque whiteouts
+	layer map[string]bool
+}
+
+func (u *unpacker) owner(uid int, gid int) (int, int, error) {
+	hostUID, err := mapID(u.ids, "u", uid)
+	if err != nil {
+		return -1, -1, err
+	}
+	hostGID, err := mapID(u.ids, "g", gid)
+	if err != nil {
+		return -1, -1, err
+	}
+	return hostUID, hostGID, nil
+}
+
+func (u *unpacker) apply(hdr *tar.Header, r io.Reader) error {
+	name := path.Clean("/" + hdr.Name)
+	if name == "/" {
+		// the root's metadata belongs to the container
+		return nil
+	}
+	dir, base := path.Split(name)
+	parent, err := u.resolve(dir, true)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case base == whiteoutOpaque:
+		return u.clear(parent)
+	case strings.HasPrefix(base, whiteoutPrefix):
+		target := strings.TrimPrefix(base, whiteoutPrefix)
+		if target == "" || target == "." || target == ".." || strings.Contains(target, "/") {
+			return fmt.Errorf("%w: whiteout %s", ErrInvalidLayout, hdr.Name)
+		}
+		return os.RemoveAll(filepath.Join(parent, target))
+	}
+
+	host := filepath.Join(parent, base)
+	u.layer[host] = true
+	// directories are merged, everything else is replaced
+	if fi, err := os.Lstat(host); err == nil && !(fi.IsDir() && hdr.Typeflag == tar.TypeDir) {
+		if err := os.RemoveAll(host); err != nil {
+			return err
+		}
+	}
+
+	uid, gid, err := u.owner(hdr.Uid, hdr.Gid)
+	if err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode()
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.Mkdir(host, 0700); err != nil && !os.IsExist(err) {
+			return err
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(host, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, host); err != nil {
+			return err
+		}
+		return os.Lchown(host, uid, gid)
+	case tar.TypeLink:
+		target := path.Clean("/" + hdr.Linkname)
+		targetDir, err := u.resolve(path.Dir(target), true)
+		if err != nil {
+			return err
+		}
+		// the target carries the metadata
+		return os.Link(filepath.Join(targetDir, path.Base(target)), host)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		kind := map[byte]uint32{tar.TypeChar: syscall.S_IFCHR, tar.TypeBlock: syscall.S_IFBLK, tar.TypeFifo: syscall.S_IFIFO}[hdr.Typeflag]
+		if err := syscall.Mknod(host, kind|0600, mkdev(hdr.Devmajor, hdr.Devminor)); err != nil {
+			return &os.PathError{Op: "mknod", Path: host, Err: err}
+		}
+	default:
+		return fmt.Errorf("%w: tar type %q", lxc.ErrNotSupported, hdr.Typeflag)
+	}
+
+	// chown clears the setuid and setgid bits, so it comes first
+	if err := os.Lchown(host, uid, gid); err != nil {
+		return err
+	}
+	if err := os.Chmod(host, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return err
+	}
+	for key, value := range hdr.PAXRecords {
+		// like security.capability of ping
+		if attr, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+			if err := syscall.Setxattr(host, attr, []byte(value), 0); err != nil {
+				return &os.PathError{Op: "setxattr", Path: host, Err: err}
+			}
+		}
+	}
+	return os.Chtimes(host, hdr.ModTime, hdr.ModTime)
+}
+
+// Encodes a device number like glibc's makedev
+func mkdev(major int64, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
+
+// Removes what the lower layers put into dir, for opaque whiteouts
+func (u *unpacker) clear(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		host := filepath.Join(dir, entry.Name())
+		if !u.layer[host] {
+			if err := os.RemoveAll(host); err != nil {
+				return err
+			}
+			continue
+		}
+		if entry.IsDir() {
+			if err := u.clear(host); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the host path of the image path name, symlinks are resolved inside
+// the rootfs. If create is set missing directories are created owned by the
+// container's root, like the lower layers would have had them.
+func (u *unpacker) resolve(name string, create bool) (string, error) {
+	resolved := "/"
+	remaining := strings.Split(name, "/")
+	links := 0
+
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			// path.Dir("/") is "/", so the root can't be left
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, component)
+		host := filepath.Join(u.root, next)
+		fi, err := os.Lstat(host)
+		if os.IsNotExist(err) && create {
+			uid, gid, err := u.owner(0, 0)
+			if err != nil {
+				return "", err
+			}
+			if err := os.Mkdir(host, 0755); err != nil {
+				return "", err
+			}
+			if err := os.Lchown(host, uid, gid); err != nil {
+				return "", err
+			}
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			links++
+			if links > maxSymlinks {
+				return "", &os.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+			}
+			target, err := os.Readlink(host)
+			if err != nil {
+				return "", err
+			}
+			if path.IsAbs(target) {
+				resolved = "/"
+			}
+			remaining = append(strings.Split(target, "/"), remaining...)
+		case fi.IsDir() || len(remaining) == 0:
+			resolved = next
+		default:
+			return "", &os.PathError{Op: "resolve", Path: name, Err: syscall.ENOTDIR}
+		}
+	}
+	return filepath.Join(u.root, resolved), nil
+}
+
+// The unpacked rootfs as an fs.FS, symlinks are resolved inside of it
+type rootDir string
+
+func (r rootDir) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	host, err := (&unpacker{root: string(r)}).resolve(name, false)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.Open(host)
+}
@@ -0,0 +1,265 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// flags missing from package syscall on some architectures
+const (
+	oPath             = 0x200000
+	atSymlinkNofollow = 0x100
+	atEmptyPath       = 0x1000
+)
+
+// Opens the clean container path name below root without following symlinks
+// in any of its components. Paths are resolved before they are opened, so a
+// component a running container replaced with a symlink meanwhile makes the
+// open fail instead of leaving root.
+func openInRoot(root string, name string, flags int) (*os.File, error) {
+	fd, err := syscall.Open(root, oPath|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	components := strings.Split(strings.Trim(path.Clean("/"+name), "/"), "/")
+	if len(components) == 1 && components[0] == "" {
+		components = nil
+	}
+	if len(components) == 0 && flags&oPath == 0 {
+		// reopened, root itself is trusted
+		defer syscall.Close(fd)
+		return reopen(fd, root, flags)
+	}
+
+	for i, component := range components {
+		next := oPath | syscall.O_DIRECTORY
+		if i == len(components)-1 {
+			next = flags
+		}
+		nextFD, err := syscall.Openat(fd, component, next|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: path.Join("/", name), Err: err}
+		}
+		fd = nextFD
+	}
+	return os.NewFile(uintptr(fd), path.Join(root, name)), nil
+}
+
+// Opens the entry base of dir without following it if it is a symlink
+func openAt(dir *os.File, base string, flags int) (*os.File, error) {
+	if err := checkBase(base); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	fd, err := syscall.Openat(int(dir.Fd()), base, flags|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0600)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	return os.NewFile(uintptr(fd), path.Join(dir.Name(), base)), nil
+}
+
+// Opens the file of an O_PATH descriptor for I/O. The magic link of the
+// descriptor is followed, which refers to the very file that was checked.
+func reopen(fd int, name string, flags int) (*os.File, error) {
+	newFD, err := syscall.Open(fmt.Sprintf("/proc/self/fd/%d", fd), flags|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return os.NewFile(uintptr(newFD), name), nil
+}
+
+// Returns a path referring to the file of f, also if it is an O_PATH one
+func fdPath(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
+
+// Returns the information of the entry base of dir, symlinks aren't followed
+func lstatAt(dir *os.File, base string) (fs.FileInfo, error) {
+	f, err := openAt(dir, base, oPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// Returns the target of the symlink base of dir
+func readlinkAt(dir *os.File, base string) (string, error) {
+	if err := checkBase(base); err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	p, err := syscall.BytePtrFromString(base)
+	if err != nil {
+		return "", err
+	}
+	for size := 256; ; size *= 2 {
+		buf := make([]byte, size)
+		n, _, errno := syscall.Syscall6(syscall.SYS_READLINKAT, dir.Fd(), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&buf[0])), uintptr(size), 0, 0)
+		if errno != 0 {
+			return "", &fs.PathError{Op: "readlink", Path: path.Join(dir.Name(), base), Err: errno}
+		}
+		if int(n) < size {
+			return string(buf[:n]), nil
+		}
+	}
+}
+
+// Entry names must not leave their directory, absolute ones would be resolved
+// against the host root
+func checkBase(base string) error {
+	if base == "" || base == "." || base == ".." || strings.Contains(base, "/") {
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+// Creates the symlink base of dir pointing to target
+func symlinkAt(target string, dir *os.File, base string) error {
+	if err := checkBase(base); err != nil {
+		return &fs.PathError{Op: "symlink", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	t, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+	p, err := syscall.BytePtrFromString(base)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(t)), dir.Fd(), uintptr(unsafe.Pointer(p)))
+	if errno != 0 {
+		return &fs.PathError{Op: "symlink", Path: path.Join(dir.Name(), base), Err: errno}
+	}
+	return nil
+}
+
+// Creates the hard link base of dir to the entry oldBase of oldDir, which
+// isn't followed if it is a symlink
+func linkAt(oldDir *os.File, oldBase string, dir *os.File, base string) error {
+	if err := checkBase(base); err != nil {
+		return &fs.PathError{Op: "link", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	if err := checkBase(oldBase); err != nil {
+		return &fs.PathError{Op: "link", Path: path.Join(oldDir.Name(), oldBase), Err: err}
+	}
+	o, err := syscall.BytePtrFromString(oldBase)
+	if err != nil {
+		return err
+	}
+	p, err := syscall.BytePtrFromString(base)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_LINKAT, oldDir.Fd(), uintptr(unsafe.Pointer(o)), dir.Fd(), uintptr(unsafe.Pointer(p)), 0, 0)
+	if errno != 0 {
+		return &fs.PathError{Op: "link", Path: path.Join(dir.Name(), base), Err: errno}
+	}
+	return nil
+}
+
+// Removes the entry base of dir, directories fail
+func unlinkAt(dir *os.File, base string) error {
+	if err := checkBase(base); err != nil {
+		return &fs.PathError{Op: "unlink", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	if err := syscall.Unlinkat(int(dir.Fd()), base); err != nil {
+		return &fs.PathError{Op: "unlink", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	return nil
+}
+
+// Creates the directory base of dir
+func mkdirAt(dir *os.File, base string, mode uint32) error {
+	if err := checkBase(base); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	if err := syscall.Mkdirat(int(dir.Fd()), base, mode); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	return nil
+}
+
+// Creates the device node or fifo base of dir
+func mknodAt(dir *os.File, base string, mode uint32, dev int) error {
+	if err := checkBase(base); err != nil {
+		return &fs.PathError{Op: "mknod", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	if err := syscall.Mknodat(int(dir.Fd()), base, mode, dev); err != nil {
+		return &fs.PathError{Op: "mknod", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	return nil
+}
+
+// Renames the entry oldBase of dir to base, replacing it
+func renameAt(dir *os.File, oldBase string, base string) error {
+	if err := checkBase(oldBase); err != nil {
+		return &fs.PathError{Op: "rename", Path: path.Join(dir.Name(), oldBase), Err: err}
+	}
+	if err := checkBase(base); err != nil {
+		return &fs.PathError{Op: "rename", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	if err := syscall.Renameat(int(dir.Fd()), oldBase, int(dir.Fd()), base); err != nil {
+		return &fs.PathError{Op: "rename", Path: path.Join(dir.Name(), base), Err: err}
+	}
+	return nil
+}
+
+// Creates a new file with a random name starting with prefix in dir, open for
+// writing
+func createTempAt(dir *os.File, prefix string) (*os.File, error) {
+	for {
+		f, err := openAt(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10), os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+		if !errors.Is(err, fs.ErrExist) {
+			return f, err
+		}
+	}
+}
+
+// Sets the owner and, unless it is a symlink, the mode of the entry base of
+// dir without following symlinks
+func chownAt(dir *os.File, base string, uid int, gid int, mode os.FileMode) error {
+	f, err := openAt(dir, base, oPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return chownFile(f, uid, gid, mode)
+}
+
+// Sets the owner and, unless it is a symlink, the mode of the file of f, which
+// may be an O_PATH one. chown clears the setuid and setgid bits, so it comes
+// first.
+func chownFile(f *os.File, uid int, gid int, mode os.FileMode) error {
+	if err := syscall.Fchownat(int(f.Fd()), "", uid, gid, atEmptyPath); err != nil {
+		return &fs.PathError{Op: "chown", Path: f.Name(), Err: err}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSymlink != 0 {
+		return nil
+	}
+	if err := os.Chmod(fdPath(f), mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		return &fs.PathError{Op: "chmod", Path: f.Name(), Err: unwrapPathError(err)}
+	}
+	return nil
+}
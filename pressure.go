@@ -0,0 +1,348 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type PressureResource int
+
+const (
+	PRESSURE_CPU PressureResource = iota + 1
+	PRESSURE_MEMORY
+	PRESSURE_IO
+)
+
+type PressureKind int
+
+const (
+	// some tasks stalled on the resource
+	PRESSURE_SOME PressureKind = iota + 1
+	// all non-idle tasks stalled on the resource at the same time
+	PRESSURE_FULL
+)
+
+const (
+	minPressureWindow = 500 * time.Millisecond
+	maxPressureWindow = 10 * time.Second
+)
+
+// PressureResource as string
+func (r PressureResource) String() string {
+	switch r {
+	case PRESSURE_CPU:
+		return "cpu"
+	case PRESSURE_MEMORY:
+		return "memory"
+	case PRESSURE_IO:
+		return "io"
+	}
+	return "<INVALID>"
+}
+
+// PressureKind as string
+func (k PressureKind) String() string {
+	switch k {
+	case PRESSURE_SOME:
+		return "some"
+	case PRESSURE_FULL:
+		return "full"
+	}
+	return "<INVALID>"
+}
+
+// Share of time (in percent) tasks were stalled over the last 10, 60 and 300
+// seconds, and the total stall time
+type PressureStats struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  time.Duration
+}
+
+// Pressure of a single resource
+type Pressure struct {
+	Some PressureStats
+	// not reported for cpu by kernels older than 5.13
+	Full PressureStats
+}
+
+// Pressure Stall Information of a container
+type ContainerPressure struct {
+	CPU    Pressure
+	Memory Pressure
+	IO     Pressure
+}
+
+// Sent when a pressure trigger fires
+type PressureEvent struct {
+	Time     time.Time
+	Resource PressureResource
+	Kind     PressureKind
+	Pressure Pressure
+}
+
+// Returns the cpu, memory and io pressure of the container's top cgroup, the
+// one NotifyPressure watches, only available on cgroup v2 hosts
+func (lxc *Container) Pressure() (*ContainerPressure, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !unifiedCgroup() {
+		return nil, ErrNotSupported
+	}
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	dir, err := lxc.cgroupPath("")
+	if err != nil {
+		return nil, err
+	}
+	pressure := &ContainerPressure{}
+	for _, r := range []struct {
+		resource PressureResource
+		pressure *Pressure
+	}{
+		{PRESSURE_CPU, &pressure.CPU},
+		{PRESSURE_MEMORY, &pressure.Memory},
+		{PRESSURE_IO, &pressure.IO},
+	} {
+		key := r.resource.String() + ".pressure"
+		p, err := readPressure(filepath.Join(dir, key))
+		if os.IsNotExist(err) || errors.Is(err, syscall.EOPNOTSUPP) {
+			// unavailable when the kernel was booted with psi=0
+			return nil, fmt.Errorf("%w: %s", ErrNotSupported, key)
+		}
+		if err != nil {
+			return nil, err
+		}
+		*r.pressure = p
+	}
+	return pressure, nil
+}
+
+// Reads the *.pressure file at path
+func readPressure(path string) (Pressure, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Pressure{}, err
+	}
+	return parsePressure(strings.Split(strings.TrimSpace(string(data)), "\n"))
+}
+
+// Parses *.pressure lines like
+// "some avg10=0.12 avg60=0.05 avg300=0.01 total=123456"
+func parsePressure(lines []string) (Pressure, error) {
+	var pressure Pressure
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var stats *PressureStats
+		switch fields[0] {
+		case "some":
+			stats = &pressure.Some
+		case "full":
+			stats = &pressure.Full
+		default:
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			if key == "total" {
+				total, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return pressure, err
+				}
+				stats.Total = time.Duration(total) * time.Microsecond
+				continue
+			}
+
+			avg, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return pressure, err
+			}
+			switch key {
+			case "avg10":
+				stats.Avg10 = avg
+			case "avg60":
+				stats.Avg60 = avg
+			case "avg300":
+				stats.Avg300 = avg
+			}
+		}
+	}
+	return pressure, nil
+}
+
+// Returns a channel receiving an event whenever the tasks of the container
+// were stalled on resource for at least stall within window (500ms-10s, newer
+// kernels require multiples of 2s from unprivileged callers). The channel is
+// closed when ctx is done or the container stops.
+func (lxc *Container) NotifyPressure(ctx context.Context, resource PressureResource, kind PressureKind, stall time.Duration, window time.Duration) (<-chan PressureEvent, error) {
+	if resource.String() == "<INVALID>" || kind.String() == "<INVALID>" {
+		return nil, fmt.Errorf("%w: pressure %s %s", ErrNotSupported, resource, kind)
+	}
+	if window < minPressureWindow || window > maxPressureWindow {
+		return nil, &InvalidLimitError{Limit: "pressure window", Value: window.String(),
+			Reason: fmt.Sprintf("must be between %s and %s", minPressureWindow, maxPressureWindow)}
+	}
+	if stall <= 0 || stall > window {
+		return nil, &InvalidLimitError{Limit: "pressure stall", Value: stall.String(), Reason: "must be positive and within the window"}
+	}
+
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !unifiedCgroup() {
+		return nil, ErrNotSupported
+	}
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	dir, err := lxc.cgroupPath("")
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, resource.String()+".pressure")
+	trigger := fmt.Sprintf("%s %d %d", kind, stall.Microseconds(), window.Microseconds())
+
+	w, err := newPressureWatcher(path, trigger)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan PressureEvent)
+	go func() {
+		defer close(ch)
+		w.watch(ctx, func() bool {
+			// fails once the cgroup is removed
+			pressure, err := readPressure(path)
+			if err != nil {
+				return false
+			}
+
+			event := PressureEvent{Time: time.Now(), Resource: resource, Kind: kind, Pressure: pressure}
+			select {
+			case ch <- event:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+	return ch, nil
+}
+
+// A PSI trigger, the kernel signals it with EPOLLPRI which the runtime poller
+// doesn't handle, so it is waited for with its own epoll instance
+type pressureWatcher struct {
+	trigger int
+	wake    int
+	epoll   int
+}
+
+func newPressureWatcher(path string, trigger string) (*pressureWatcher, error) {
+	w := &pressureWatcher{trigger: -1, wake: -1, epoll: -1}
+
+	var err error
+	w.trigger, err = syscall.Open(path, syscall.O_RDWR|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	// the trigger stays active as long as the descriptor is open
+	if _, err := syscall.Write(w.trigger, append([]byte(trigger), 0)); err != nil {
+		w.close()
+		return nil, &os.PathError{Op: "write", Path: path, Err: err}
+	}
+
+	wake, _, errno := syscall.RawSyscall(syscall.SYS_EVENTFD2, 0, efdCloexec|efdNonblock, 0)
+	if errno != 0 {
+		w.close()
+		return nil, os.NewSyscallError("eventfd2", errno)
+	}
+	w.wake = int(wake)
+
+	w.epoll, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		w.close()
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	for fd, events := range map[int]uint32{w.trigger: syscall.EPOLLPRI, w.wake: syscall.EPOLLIN} {
+		event := syscall.EpollEvent{Events: events, Fd: int32(fd)}
+		if err := syscall.EpollCtl(w.epoll, syscall.EPOLL_CTL_ADD, fd, &event); err != nil {
+			w.close()
+			return nil, os.NewSyscallError("epoll_ctl", err)
+		}
+	}
+	return w, nil
+}
+
+// Calls fn whenever the trigger fires until fn returns false, ctx is done or
+// the cgroup is removed. The watcher is closed on return.
+func (w *pressureWatcher) watch(ctx context.Context, fn func() bool) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// interrupts epoll_wait, the descriptors are closed once
+			// the loop below returned
+			one := []byte{1, 0, 0, 0, 0, 0, 0, 0}
+			syscall.Write(w.wake, one)
+			<-done
+		case <-done:
+		}
+		w.close()
+	}()
+
+	events := make([]syscall.EpollEvent, 2)
+	for {
+		n, err := syscall.EpollWait(w.epoll, events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		for _, event := range events[:n] {
+			if int(event.Fd) == w.wake || event.Events&syscall.EPOLLERR != 0 {
+				return
+			}
+			if event.Events&syscall.EPOLLPRI != 0 && !fn() {
+				return
+			}
+		}
+	}
+}
+
+func (w *pressureWatcher) close() {
+	for _, fd := range []int{w.trigger, w.wake, w.epoll} {
+		if fd != -1 {
+			syscall.Close(fd)
+		}
+	}
+}
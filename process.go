@@ -0,0 +1,259 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A task running in a container
+type Process struct {
+	// PID on the host and inside the container's PID namespace
+	PID   int
+	NSPID int
+	PPID  int
+
+	// real user ID on the host and inside the container's user namespace,
+	// User is looked up in the container's /etc/passwd
+	HostUID int
+	UID     int
+	User    string
+
+	Command string
+	CmdLine []string
+	// R (running), S (sleeping), D (disk sleep), Z (zombie), T (stopped)...
+	State   string
+	RSS     ByteSize
+	CPUTime time.Duration
+}
+
+// Returns the tasks in the container's cgroup, including nested cgroups
+func (lxc *Container) Processes() ([]Process, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+
+	// liblxc relies on the freezer to find all tasks on cgroup v1 hosts. The
+	// top cgroup is walked, on cgroup v2 hosts its processes are in nested
+	// cgroups like the init.scope and system.slice of systemd.
+	dir, err := lxc.cgroupPath("freezer")
+	if err != nil {
+		return nil, err
+	}
+	pids, err := cgroupProcs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	initPID := lxc.initPID()
+	uidMap, _ := os.ReadFile(fmt.Sprintf("/proc/%d/uid_map", initPID))
+	idMap := parseIDMap(string(uidMap))
+	passwd, _ := os.ReadFile(fmt.Sprintf("/proc/%d/root/etc/passwd", initPID))
+	users := parsePasswd(string(passwd))
+
+	processes := make([]Process, 0, len(pids))
+	for _, pid := range pids {
+		p, err := readProcess(pid)
+		if err != nil {
+			// exited in the meantime
+			continue
+		}
+		p.UID = containerID(idMap, p.HostUID)
+		p.User = users[p.UID]
+		processes = append(processes, *p)
+	}
+	return processes, nil
+}
+
+// Returns the sorted PIDs of the processes in the cgroup and its children
+func cgroupProcs(dir string) ([]int, error) {
+	var pids []int
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// children may go away while walking
+			if path != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(filepath.Join(path, "cgroup.procs"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		for _, field := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Ints(pids)
+	return pids, nil
+}
+
+func readProcess(pid int) (*Process, error) {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	p, err := parseProcStat(string(stat))
+	if err != nil {
+		return nil, err
+	}
+
+	p.NSPID = p.PID
+	if nspid, ok := procStatusField(pid, "NSpid"); ok {
+		// the innermost namespace comes last
+		if fields := strings.Fields(nspid); len(fields) > 0 {
+			if n, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				p.NSPID = n
+			}
+		}
+	}
+	p.HostUID = -1
+	if uid, ok := procStatusField(pid, "Uid"); ok {
+		// real, effective, saved and filesystem UIDs
+		if fields := strings.Fields(uid); len(fields) > 0 {
+			if n, err := strconv.Atoi(fields[0]); err == nil {
+				p.HostUID = n
+			}
+		}
+	}
+
+	cmdline, err := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err == nil && len(cmdline) > 0 {
+		for _, arg := range bytes.Split(bytes.TrimSuffix(cmdline, []byte{0}), []byte{0}) {
+			p.CmdLine = append(p.CmdLine, string(arg))
+		}
+	}
+	return p, nil
+}
+
+// Parses /proc/<pid>/stat, the command is in parentheses and may contain
+// spaces and parentheses itself
+func parseProcStat(data string) (*Process, error) {
+	start := strings.IndexByte(data, '(')
+	end := strings.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid stat %q", data)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(data[:start]))
+	if err != nil {
+		return nil, err
+	}
+	// fields after the command, starting with the state (field 3)
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat %q", data)
+	}
+
+	p := &Process{PID: pid, Command: data[start+1 : end], State: fields[0]}
+	if p.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, err
+	}
+	utime, err := strconv.ParseInt(fields[11], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	stime, err := strconv.ParseInt(fields[12], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	p.CPUTime = time.Duration(utime+stime) * time.Second / userHZ
+	rss, err := strconv.ParseInt(fields[21], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	p.RSS = ByteSize(rss * int64(os.Getpagesize()))
+	return p, nil
+}
+
+// a range of a uid_map or gid_map, "inside outside count"
+type idMapping struct {
+	inside  int64
+	outside int64
+	count   int64
+}
+
+func parseIDMap(data string) []idMapping {
+	var mappings []idMapping
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		var values [3]int64
+		valid := true
+		for i, field := range fields {
+			n, err := strconv.ParseInt(field, 10, 64)
+			if err != nil {
+				valid = false
+				break
+			}
+			values[i] = n
+		}
+		if valid {
+			mappings = append(mappings, idMapping{inside: values[0], outside: values[1], count: values[2]})
+		}
+	}
+	return mappings
+}
+
+// Maps a host ID into the container, -1 if it isn't mapped
+func containerID(mappings []idMapping, id int) int {
+	for _, m := range mappings {
+		if int64(id) >= m.outside && int64(id) < m.outside+m.count {
+			return int(m.inside + int64(id) - m.outside)
+		}
+	}
+	return -1
+}
+
+// Returns the user names of an /etc/passwd file keyed by their UIDs
+func parsePasswd(data string) map[int]string {
+	users := make(map[int]string)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := users[uid]; !ok {
+			users[uid] = fields[0]
+		}
+	}
+	return users
+}
@@ -0,0 +1,435 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// symlinks followed while resolving a single path, like the kernel's limit
+const maxSymlinks = 40
+
+// Returns a read-only view of the container's root filesystem. Running
+// containers are accessed through their mount namespace, stopped ones through
+// their dir, btrfs or overlay rootfs. Symlinks are resolved relative to the
+// container root, they can't point outside of it. Only regular files and
+// directories can be opened.
+func (lxc *Container) RootFS() (fs.FS, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+
+	r, err := lxc.containerRoot()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (lxc *Container) containerRoot() (*rootFS, error) {
+	if lxc.running() {
+		return &rootFS{layers: []string{fmt.Sprintf("/proc/%d/root", lxc.initPID())}}, nil
+	}
+
+	value, ok, err := lxc.configValue("lxc.rootfs.path")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: lxc.rootfs.path is not set", ErrInvalidConfigValue)
+	}
+	layers, err := rootFSLayers(value)
+	if err != nil {
+		return nil, err
+	}
+	return &rootFS{layers: layers}, nil
+}
+
+// Returns the directories of a lxc.rootfs.path value, the upper one first for
+// overlay rootfs like "overlay:/lower:/upper"
+func rootFSLayers(value string) ([]string, error) {
+	kind, rest, ok := strings.Cut(value, ":")
+	if !ok {
+		return []string{value}, nil
+	}
+
+	switch kind {
+	case "dir", "btrfs":
+		return []string{rest}, nil
+	case "overlay", "overlayfs":
+		dirs := strings.Split(rest, ":")
+		if len(dirs) < 2 {
+			return nil, fmt.Errorf("%w: lxc.rootfs.path = %s", ErrInvalidConfigValue, value)
+		}
+		layers := []string{dirs[len(dirs)-1]}
+		return append(layers, dirs[:len(dirs)-1]...), nil
+	}
+	// block devices and images have to be mounted first
+	return nil, fmt.Errorf("%w: %s rootfs of a stopped container", ErrNotSupported, kind)
+}
+
+// An fs.FS over one directory, or over the layers of an overlay filesystem
+type rootFS struct {
+	layers []string
+}
+
+// Open implements fs.FS
+func (r *rootFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	resolved, err := r.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	layer, _, err := r.lookup(resolved)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	// the type is checked on the opened file, which may differ from the one
+	// resolve saw if the container replaced it
+	f, err := openInRoot(layer, resolved, oPath)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+	}
+
+	switch {
+	case fi.Mode().IsRegular():
+		file, err := reopen(int(f.Fd()), f.Name(), syscall.O_RDONLY)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+		}
+		return file, nil
+	case fi.IsDir():
+		if len(r.layers) > 1 {
+			return &overlayDir{fs: r, path: resolved, name: name, info: fi}, nil
+		}
+		dir, err := reopen(int(f.Fd()), f.Name(), syscall.O_RDONLY|syscall.O_DIRECTORY)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: unwrapPathError(err)}
+		}
+		return &dirFile{File: dir}, nil
+	}
+	// opening devices or fifos could block or touch the host
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+// A directory of a single layer, the information of its entries is read
+// relative to its descriptor
+type dirFile struct {
+	*os.File
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *dirFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	return readDirAt(d.File, n)
+}
+
+// Reads the entries of dir with their information, entries removed meanwhile
+// are left out
+func readDirAt(dir *os.File, n int) ([]fs.DirEntry, error) {
+	entries, err := dir.ReadDir(n)
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		fi, statErr := lstatAt(dir, entry.Name())
+		if errors.Is(statErr, fs.ErrNotExist) {
+			continue
+		}
+		if statErr != nil {
+			return result, statErr
+		}
+		result = append(result, fs.FileInfoToDirEntry(fi))
+	}
+	return result, err
+}
+
+// Lstat returns the information of name without following a final symlink
+func (r *rootFS) Lstat(name string) (fs.FileInfo, error) {
+	_, _, fi, err := r.lookupNoFollow("lstat", name)
+	return fi, err
+}
+
+// ReadLink returns the target of the symlink name
+func (r *rootFS) ReadLink(name string) (string, error) {
+	layer, resolved, fi, err := r.lookupNoFollow("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+
+	target, err := readlinkIn(layer, resolved)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: unwrapPathError(err)}
+	}
+	return target, nil
+}
+
+// Returns the layer, the container path with resolved parents and the
+// information of name, a final symlink isn't followed
+func (r *rootFS) lookupNoFollow(op string, name string) (string, string, fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return "", "", nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	parent, err := r.resolve(path.Dir(name))
+	if err != nil {
+		return "", "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	resolved := path.Join(parent, path.Base(name))
+	layer, fi, err := r.lookup(resolved)
+	if err != nil {
+		return "", "", nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return layer, resolved, fi, nil
+}
+
+// Returns the target of the symlink at the container path name of layer
+func readlinkIn(layer string, name string) (string, error) {
+	dir, err := openInRoot(layer, path.Dir(name), oPath|syscall.O_DIRECTORY)
+	if err != nil {
+		return "", err
+	}
+	defer dir.Close()
+	return readlinkAt(dir, path.Base(name))
+}
+
+func unwrapPathError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// Resolves the symlinks in name, returns an absolute path in the container
+func (r *rootFS) resolve(name string) (string, error) {
+	return r.resolvePath(name, nil)
+}
+
+// Like resolve, missing directories are created by create if it is set
+func (r *rootFS) resolvePath(name string, create func(string) error) (string, error) {
+	resolved := "/"
+	remaining := strings.Split(name, "/")
+	links := 0
+
+	for len(remaining) > 0 {
+		component := remaining[0]
+		remaining = remaining[1:]
+
+		switch component {
+		case "", ".":
+			continue
+		case "..":
+			// path.Dir("/") is "/", so the root can't be left
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, component)
+		layer, fi, err := r.lookup(next)
+		if errors.Is(err, fs.ErrNotExist) && create != nil {
+			if err := create(next); err != nil {
+				return "", err
+			}
+			resolved = next
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", syscall.ELOOP
+		}
+		target, err := readlinkIn(layer, next)
+		if err != nil {
+			return "", unwrapPathError(err)
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		remaining = append(strings.Split(target, "/"), remaining...)
+	}
+	return resolved, nil
+}
+
+// Returns the layer holding a container path whose parents don't contain
+// symlinks and its information, looking through the layers from the upper one
+func (r *rootFS) lookup(name string) (string, fs.FileInfo, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		root, err := openInRoot(r.layers[0], "/", oPath)
+		if err != nil {
+			return "", nil, unwrapPathError(err)
+		}
+		defer root.Close()
+		fi, err := root.Stat()
+		return r.layers[0], fi, err
+	}
+
+	for _, layer := range r.layers {
+		dir, err := openInRoot(layer, path.Dir(name), oPath|syscall.O_DIRECTORY)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", nil, unwrapPathError(err)
+		}
+		fi, err := lstatAt(dir, path.Base(name))
+		opaque := isOpaque(dir)
+		dir.Close()
+
+		if err == nil {
+			if isWhiteout(fi) {
+				return "", nil, fs.ErrNotExist
+			}
+			return layer, fi, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", nil, unwrapPathError(err)
+		}
+		if opaque {
+			break
+		}
+	}
+	return "", nil, fs.ErrNotExist
+}
+
+// overlayfs hides lower files with 0:0 character devices
+func isWhiteout(fi fs.FileInfo) bool {
+	if fi.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+// overlayfs hides the contents of lower directories behind opaque ones
+func isOpaque(dir *os.File) bool {
+	value := make([]byte, 1)
+	for _, attr := range []string{"trusted.overlay.opaque", "user.overlay.opaque"} {
+		if n, err := syscall.Getxattr(fdPath(dir), attr, value); err == nil && n == 1 && value[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
+
+// A directory merged from the layers of an overlay filesystem
+type overlayDir struct {
+	fs      *rootFS
+	path    string
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *overlayDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *overlayDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: syscall.EISDIR}
+}
+
+func (d *overlayDir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.merge()
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: err}
+		}
+		d.entries, d.read = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+func (d *overlayDir) merge() ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+
+	for _, layer := range d.fs.layers {
+		dir, err := openInRoot(layer, d.path, syscall.O_RDONLY|syscall.O_DIRECTORY)
+		// upper directories hide lower files of the same name
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			continue
+		}
+		if err != nil {
+			return nil, unwrapPathError(err)
+		}
+		layerEntries, err := readDirAt(dir, -1)
+		opaque := isOpaque(dir)
+		dir.Close()
+		if err != nil {
+			return nil, unwrapPathError(err)
+		}
+
+		for _, entry := range layerEntries {
+			if seen[entry.Name()] {
+				continue
+			}
+			seen[entry.Name()] = true
+			if entry.Type()&fs.ModeCharDevice != 0 {
+				if fi, err := entry.Info(); err == nil && isWhiteout(fi) {
+					continue
+				}
+			}
+			entries = append(entries, entry)
+		}
+		if opaque {
+			break
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
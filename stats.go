@@ -0,0 +1,140 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Resource usage of a container at a single point in time. Values the host
+// doesn't account (like swap without swap accounting) are left zero.
+type Stats struct {
+	Time time.Time
+
+	MemoryUsage ByteSize
+	MemoryLimit ByteSize
+	// memory plus swap, like memory.memsw.*
+	SwapUsage ByteSize
+	SwapLimit ByteSize
+	Memory    *MemoryStats
+
+	CPUTime time.Duration
+	// nil on cgroup v2 hosts
+	CPUTimePerCPU []time.Duration
+	CPUUser       time.Duration
+	CPUSystem     time.Duration
+
+	BlockIO []BlockIOStat
+
+	PidsCurrent uint64
+	// -1 without a limit
+	PidsLimit int64
+
+	Network []NetworkStats
+}
+
+// Returns the resource usage of the container, all values being read at once
+func (lxc *Container) Stats() (*Stats, error) {
+	lxc.mu.RLock()
+	defer lxc.mu.RUnlock()
+	if !lxc.running() {
+		return nil, ErrNotRunning
+	}
+	return lxc.stats()
+}
+
+func (lxc *Container) stats() (*Stats, error) {
+	stats := &Stats{Time: time.Now(), PidsLimit: -1}
+
+	var err error
+	if stats.MemoryUsage, err = lxc.memoryUsage(); err != nil {
+		return nil, err
+	}
+	if stats.MemoryLimit, err = lxc.memoryLimit(); err != nil {
+		return nil, err
+	}
+	if stats.Memory, err = lxc.memoryStats(); err != nil {
+		return nil, err
+	}
+	if stats.CPUTime, err = lxc.cpuTime(); err != nil {
+		return nil, err
+	}
+	cpuStats, err := lxc.cpuStats()
+	if err != nil {
+		return nil, err
+	}
+	stats.CPUUser = time.Duration(cpuStats[0]) * time.Second / userHZ
+	stats.CPUSystem = time.Duration(cpuStats[1]) * time.Second / userHZ
+
+	// the rest depends on the kernel and the enabled controllers
+	if usage, err := lxc.swapUsage(); err == nil {
+		stats.SwapUsage = usage
+	}
+	if limit, err := lxc.swapLimit(); err == nil {
+		stats.SwapLimit = limit
+	}
+	if perCPU, err := lxc.cpuTimePerCPU(); err == nil {
+		stats.CPUTimePerCPU = perCPU
+	} else if !errors.Is(err, ErrNotSupported) && !errors.Is(err, ErrReadingCgroupItem) {
+		return nil, err
+	}
+	if blkio, err := lxc.blockIOStats(); err == nil {
+		stats.BlockIO = blkio
+	}
+	if current, err := lxc.cgroupValue("pids.current"); err == nil {
+		stats.PidsCurrent, _ = strconv.ParseUint(current, 10, 64)
+	}
+	if limit, err := lxc.cgroupValue("pids.max"); err == nil && limit != "max" {
+		stats.PidsLimit, _ = strconv.ParseInt(limit, 10, 64)
+	}
+	if network, err := lxc.networkStats(); err == nil {
+		stats.Network = network
+	}
+	return stats, nil
+}
+
+// Returns a channel receiving the resource usage of the container right away
+// and then every interval. The channel is closed when ctx is done or reading
+// the stats fails, like once the container stopped. interval must be positive.
+func (lxc *Container) StreamStats(ctx context.Context, interval time.Duration) (<-chan Stats, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("%w: stats interval %s must be positive", ErrInvalidInterval, interval)
+	}
+	ch := make(chan Stats)
+
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			stats, err := lxc.Stats()
+			if err != nil {
+				return
+			}
+			select {
+			case ch <- *stats:
+			case <-ctx.Done():
+				return
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,292 @@
+// Copyright © 2013, S.Çağlar Onur
+// Use of this source code is governed by a LGPLv2.1
+// license that can be found in the LICENSE file.
+//
+// Authors:
+// S.Çağlar Onur <caglar@10ur.org>
+
+// +build linux
+
+package lxc
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A container template implemented in Go, used by CreateFromTemplate
+type Template interface {
+	// Writes the root filesystem of the container as a tar stream, the owners
+	// of the entries are IDs of the container
+	Populate(tw *tar.Writer) error
+	// Sets the config items of the template
+	Configure(tx *ConfigTx) error
+}
+
+// Options of CreateFromTemplate
+type TemplateOptions struct {
+	// lxc.idmap values of unprivileged containers, like "u 0 100000 65536"
+	IDMap []string
+	// Creates the char and block devices the template writes, which are
+	// refused otherwise. Only set it for trusted templates, device nodes give
+	// the container access to the host's devices.
+	Devices bool
+}
+
+// directory of the configuration shipped with LXC, included if present on the
+// host
+const sharedConfigDir = "/usr/share/lxc/config"
+
+// Creates the container using a template implemented in Go, without running
+// any of liblxc's shell templates. Like containers created by liblxc, the
+// config starts with the items of lxc.default_config. The rootfs is a
+// directory below the container's directory, owned by the IDs mapped with
+// opts.IDMap. Nothing is left behind if the template fails.
+func (lxc *Container) CreateFromTemplate(t Template, opts TemplateOptions) error {
+	return lxc.createFromTemplate(t, opts, "")
+}
+
+// Creates the container like CreateFromTemplate, including the configuration
+// LXC ships for distro if there is one
+func (lxc *Container) createFromTemplate(t Template, opts TemplateOptions, distro string) error {
+	if lxc.Defined() {
+		return ErrAlreadyDefined
+	}
+	for _, entry := range opts.IDMap {
+		if err := validateConfigItem("lxc.idmap", entry); err != nil {
+			return err
+		}
+	}
+
+	name := lxc.Name()
+	dir := filepath.Join(lxc.ConfigPath(), name)
+	// fails if the container exists
+	if err := os.Mkdir(dir, 0750); err != nil {
+		return err
+	}
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := populate(t, rootfs, parseConfigIDMap(strings.Join(opts.IDMap, "\n")), opts.Devices); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+
+	err := lxc.EditConfig(func(tx *ConfigTx) error {
+		if err := setConfigFileItems(tx, defaultConfigFile()); err != nil {
+			return err
+		}
+		tx.Set("lxc.rootfs.path", rootfs)
+		tx.Set("lxc.uts.name", name)
+		if include := sharedConfig(distro, "common"); include != "" {
+			tx.Set("lxc.include", include)
+		}
+		if include := sharedConfig(distro, "userns"); include != "" && len(opts.IDMap) > 0 {
+			tx.Set("lxc.include", include)
+		}
+		for _, entry := range opts.IDMap {
+			tx.Set("lxc.idmap", entry)
+		}
+		return t.Configure(tx)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	return nil
+}
+
+// Returns the path of liblxc's lxc.default_config, the config new containers
+// start from, set in lxc.conf
+func defaultConfigFile() string {
+	dir := "/etc/lxc"
+	if os.Geteuid() != 0 {
+		if config, err := os.UserConfigDir(); err == nil {
+			dir = filepath.Join(config, "lxc")
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "lxc.conf")); err == nil {
+		if values := configFileValues(string(data), "lxc.default_config"); len(values) > 0 {
+			return values[len(values)-1]
+		}
+	}
+	return filepath.Join(dir, "default.conf")
+}
+
+// Sets the items of the config file name, which may be missing
+func setConfigFileItems(tx *ConfigTx, name string) error {
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, value, ok := parseConfigLine(line); ok {
+			tx.Set(modernConfigKey(key), value)
+		}
+	}
+	return nil
+}
+
+// Returns the <distro>.<kind>.conf shipped with LXC, or <kind>.conf if there
+// is none for distro, which the distribution's one includes. Empty if neither
+// is present.
+func sharedConfig(distro string, kind string) string {
+	for _, name := range []string{distro + "." + kind + ".conf", kind + ".conf"} {
+		if strings.HasPrefix(name, ".") || strings.Contains(name, "/") {
+			continue
+		}
+		include := filepath.Join(sharedConfigDir, name)
+		if _, err := os.Stat(include); err == nil {
+			return include
+		}
+	}
+	return ""
+}
+
+// Extracts the stream written by the template into rootfs, devices tells
+// whether char and block devices are created
+func populate(t Template, rootfs string, ids idMaps, devices bool) error {
+	uid, gid, err := ids.toHost(0, 0)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		return err
+	}
+	if err := os.Chown(rootfs, uid, gid); err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := t.Populate(tw)
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	files := &containerFiles{root: &rootFS{layers: []string{rootfs}}, ids: ids, devices: devices}
+	err = files.copyIn(pr, "/")
+	// unblocks the template if extracting failed
+	pr.CloseWithError(err)
+	return err
+}
+
+// Populates the rootfs from a local tarball of a root filesystem, plain, gzip
+// or bzip2 compressed, like the rootfs.tar of a distribution's image
+type TarballTemplate struct {
+	Path string
+	// lxc.arch of the container, like "amd64", unset if empty
+	Arch string
+}
+
+// Populate implements Template
+func (t *TarballTemplate) Populate(tw *tar.Writer) error {
+	f, err := os.Open(t.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := copyTarball(tw, f); err != nil {
+		return fmt.Errorf("%s: %w", t.Path, err)
+	}
+	return nil
+}
+
+// Copies the entries of a possibly compressed tarball to tw
+func copyTarball(tw *tar.Writer, r io.Reader) error {
+	r, err := decompress(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// Configure implements Template
+func (t *TarballTemplate) Configure(tx *ConfigTx) error {
+	if t.Arch != "" {
+		tx.Set("lxc.arch", t.Arch)
+	}
+	return nil
+}
+
+// Returns a reader of the decompressed stream, detected by its magic bytes
+func decompress(r *bufio.Reader) (io.Reader, error) {
+	magic, _ := r.Peek(6)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(magic, []byte("BZh")):
+		return bzip2.NewReader(r), nil
+	case bytes.HasPrefix(magic, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return nil, fmt.Errorf("%w: xz compression", ErrNotSupported)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return nil, fmt.Errorf("%w: zstd compression", ErrNotSupported)
+	}
+	return r, nil
+}
+
+// Populates the rootfs with a copy of a host directory. Symlinks are copied as
+// they are, hard links become separate files.
+type DirectoryTemplate struct {
+	Path string
+	// lxc.idmap values the owners in the directory are shifted by, like the
+	// ones of the unprivileged container the directory is the rootfs of. The
+	// owners are IDs of the container if empty.
+	IDMap []string
+	// lxc.arch of the container, like "amd64", unset if empty
+	Arch string
+}
+
+// Populate implements Template
+func (t *DirectoryTemplate) Populate(tw *tar.Writer) error {
+	fi, err := os.Stat(t.Path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%w: %s is not a directory", ErrInvalidConfigValue, t.Path)
+	}
+	src := &containerFiles{
+		root: &rootFS{layers: []string{t.Path}},
+		ids:  parseConfigIDMap(strings.Join(t.IDMap, "\n")),
+	}
+	return src.addTree(tw, ".", treeOptions{})
+}
+
+// Configure implements Template
+func (t *DirectoryTemplate) Configure(tx *ConfigTx) error {
+	if t.Arch != "" {
+		tx.Set("lxc.arch", t.Arch)
+	}
+	return nil
+}